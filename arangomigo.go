@@ -8,6 +8,7 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"time"
 
 	"github.com/pkg/errors"
 	"gopkg.in/yaml.v2"
@@ -25,6 +26,20 @@ func TriggerMigration(configAt string) {
 	log.Println("Successfully completed migration")
 }
 
+// Lint checks the migrations and that the server can run them, without
+// running them, warning about anything the server deprecates.
+func Lint(configAt string) {
+	config, err := loadConf(configAt)
+	if e(err) {
+		log.Fatal(err)
+	}
+
+	if err := lint(context.Background(), *config); err != nil {
+		log.Fatal("Found problems with the migrations\n", err)
+	}
+	log.Println("Found no problems with the migrations")
+}
+
 // TODO remember that having replayable migrations need to be possible too.
 // Have branch those into running at the end.
 
@@ -39,6 +54,39 @@ func migrate(c Config) error {
 	return perform(ctx, c, pm)
 }
 
+func lint(ctx context.Context, c Config) error {
+	pms, err := migrations(c.MigrationsPath)
+	if e(err) {
+		return err
+	}
+	cl, err := client(c)
+	if e(err) {
+		return err
+	}
+	if err := waitForArango(ctx, c, cl); e(err) {
+		return err
+	}
+	var ran map[string]bool
+	if c.multiTenant() {
+		if err := checkTenants(c, pms); e(err) {
+			return err
+		}
+		ran, err = appliedEverywhere(ctx, c, cl, pms)
+	} else {
+		if err := preflight(ctx, c, cl, pms); e(err) {
+			return err
+		}
+		ran, err = applied(ctx, cl, c.Db, pms)
+	}
+	if e(err) {
+		return err
+	}
+	if err := validate(pms, ran); e(err) {
+		return err
+	}
+	return checkRequirements(ctx, cl, pending(pms, ran))
+}
+
 // Reads in a yaml file at the confLoc and returns the Config instance.
 func loadConf(confLoc string) (*Config, error) {
 	bytes, _, err := open(confLoc)
@@ -52,7 +100,10 @@ func loadConf(confLoc string) (*Config, error) {
 		return nil, errors.Wrapf(err, "Couldn't parse configation at path '%s'", confLoc)
 	}
 
-	if conf.Db == "" {
+	if err := checkTenants(conf, nil); e(err) {
+		return nil, err
+	}
+	if conf.Db == "" && !conf.multiTenant() {
 		return nil, errors.New("Please specifiy the database name in the config")
 	}
 	encased := make(map[string]interface{})
@@ -94,6 +145,43 @@ type Config struct {
 	MigrationsPath StringArray
 	Db             string
 	SkipSslVerify  bool `yaml:"skip_ssl_verify"`
+	// JWT is a bearer token used instead of the username and password.
+	JWT string `yaml:"jwt"`
+	// JWTFile is the path of a file holding the bearer token.
+	JWTFile string `yaml:"jwt_file"`
+	// CAFile is a PEM bundle used to verify the server's certificate.
+	CAFile string `yaml:"ca_file"`
+	// ClientCert and ClientKey are the PEM files presented for mutual TLS.
+	ClientCert string `yaml:"client_cert"`
+	ClientKey  string `yaml:"client_key"`
+	// Connection is the protocol used to talk to Arango: http, http2 or vst.
+	Connection ConnectionType `yaml:"connection"`
+	// RequestTimeout bounds each request that has no deadline of its own.
+	RequestTimeout time.Duration `yaml:"request_timeout"`
+	// MigrationTimeout bounds the time a single migration may take.
+	MigrationTimeout time.Duration `yaml:"migration_timeout"`
+	// Retries is how many times a transient failure is retried.
+	Retries int `yaml:"retries"`
+	// RetryBackoff is the wait before the first retry. It doubles each time.
+	RetryBackoff time.Duration `yaml:"retry_backoff"`
+	// SynchronizeEndpoints discovers the cluster's coordinators before migrating.
+	SynchronizeEndpoints bool `yaml:"synchronize_endpoints"`
+	// WaitFor is how long to wait for a healthy server before migrating.
+	WaitFor time.Duration `yaml:"wait_for"`
+	// WaitInterval is the pause between health checks while waiting.
+	WaitInterval time.Duration `yaml:"wait_interval"`
+	// IndexWait is how long to wait for an index to finish building.
+	IndexWait time.Duration `yaml:"index_wait"`
+	// IndexWaitInterval is the pause between checks of an index's progress.
+	IndexWaitInterval time.Duration `yaml:"index_wait_interval"`
+	// Databases lists the tenant databases to migrate instead of Db.
+	Databases []string `yaml:"databases"`
+	// DatabasePattern is a regular expression matching the tenant databases' names.
+	DatabasePattern string `yaml:"database_pattern"`
+	// DatabaseQuery is AQL run in _system that returns the tenant databases' names.
+	DatabaseQuery string `yaml:"database_query"`
+	// Concurrency is how many tenant databases migrate at once. Defaults to 1.
+	Concurrency int `yaml:"concurrency"`
 	// Extras allows the user to pass in replaced variables
 	Extras map[string]interface{}
 }
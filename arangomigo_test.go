@@ -231,6 +231,12 @@ func TestKeyGeneratorType(t *testing.T) {
 	assert.NoError(t, err, "Could not read collection properties")
 
 	assert.Equal(t, "uuid", string(collProps.KeyOptions.Type), "Key generator should be uuid")
+
+	tickets, err := db.Collection(ctx, "tickets")
+	assert.NoError(t, err, "Could not find tickets collection")
+	ticketProps, err := tickets.Properties(ctx)
+	assert.NoError(t, err, "Could not read collection properties")
+	assert.Equal(t, driver.KeyGeneratorAutoIncrement, ticketProps.KeyOptions.Type)
 }
 
 type recipe struct {
@@ -1,5 +1,9 @@
 /*
 Package main allows the tool to execute from the command line.
+
+	arangomigo <config>          runs the migrations
+	arangomigo lint <config>     checks them without running them
+	arangomigo convert <config>  rewrites deprecated index types
 */
 package main
 
@@ -11,12 +15,24 @@ import (
 )
 
 func main() {
+	args := os.Args[1:]
+	command := ""
+	if len(args) > 0 && (args[0] == "lint" || args[0] == "convert") {
+		command, args = args[0], args[1:]
+	}
 	configAt := ""
-	if len(os.Args) > 1 {
-		configAt = os.Args[1]
+	if len(args) > 0 {
+		configAt = args[0]
 	} else {
 		log.Fatal("Please specify the path for the configuration")
 	}
 
-	arangomigo.TriggerMigration(configAt)
+	switch command {
+	case "lint":
+		arangomigo.Lint(configAt)
+	case "convert":
+		arangomigo.ConvertDeprecated(configAt)
+	default:
+		arangomigo.TriggerMigration(configAt)
+	}
 }
@@ -0,0 +1,275 @@
+package arangomigo
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+	"log"
+	"net"
+	nethttp "net/http"
+	"strings"
+	"time"
+
+	"github.com/arangodb/go-driver"
+	"github.com/arangodb/go-driver/cluster"
+	"github.com/arangodb/go-driver/http"
+	"github.com/arangodb/go-driver/util"
+	"github.com/arangodb/go-driver/vst"
+	"github.com/arangodb/go-driver/vst/protocol"
+	"github.com/pkg/errors"
+	"golang.org/x/net/http2"
+)
+
+// ConnectionType the protocol used to talk to ArangoDB.
+type ConnectionType string
+
+// Enumerated values for the ConnectionType
+const (
+	HTTP  ConnectionType = "http"
+	HTTP2 ConnectionType = "http2"
+	VST   ConnectionType = "vst"
+)
+
+const defaultRetryBackoff = 500 * time.Millisecond
+
+// Create the client used to talk to ArangoDB
+func client(c Config) (driver.Client, error) {
+	auth, err := authentication(c)
+	if e(err) {
+		return nil, err
+	}
+	tlsConf, err := tlsConfig(c)
+	if e(err) {
+		return nil, err
+	}
+
+	conn, err := connection(c, tlsConf)
+	if e(err) {
+		return nil, errors.New("Couldn't create connection to Arango\n" + err.Error())
+	}
+
+	if c.Retries > 0 {
+		backoff := c.RetryBackoff
+		if backoff <= 0 {
+			backoff = defaultRetryBackoff
+		}
+		conn = retryConnection{Connection: conn, retries: c.Retries, backoff: backoff}
+	}
+
+	cl, err := driver.NewClient(driver.ClientConfig{
+		Connection:     conn,
+		Authentication: auth,
+	})
+	if e(err) {
+		return nil, err
+	}
+
+	if c.SynchronizeEndpoints {
+		ctx := context.Background()
+		if c.RequestTimeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, c.RequestTimeout)
+			defer cancel()
+		}
+		err = cl.SynchronizeEndpoints2(ctx, "")
+		if e(err) {
+			return nil, errors.Wrap(err, "Couldn't synchronize the cluster endpoints")
+		}
+		log.Printf("Synchronized endpoints %v\n", cl.Connection().Endpoints())
+	}
+
+	return cl, nil
+}
+
+// connection opens the driver connection for the configured protocol.
+func connection(c Config, tlsConf *tls.Config) (driver.Connection, error) {
+	timeouts := cluster.ConnectionConfig{DefaultTimeout: c.RequestTimeout}
+
+	switch ConnectionType(strings.ToLower(string(c.Connection))) {
+	case "", HTTP:
+		return http.NewConnection(http.ConnectionConfig{
+			Endpoints:        c.Endpoints,
+			TLSConfig:        tlsConf,
+			ConnectionConfig: timeouts,
+		})
+	case HTTP2:
+		transport, err := http2Transport(c.Endpoints, tlsConf)
+		if e(err) {
+			return nil, err
+		}
+		return http.NewConnection(http.ConnectionConfig{
+			Endpoints:        c.Endpoints,
+			Transport:        transport,
+			ConnectionConfig: timeouts,
+		})
+	case VST:
+		return vst.NewConnection(vst.ConnectionConfig{
+			Endpoints:        c.Endpoints,
+			TLSConfig:        tlsConf,
+			Transport:        protocol.TransportConfig{Version: protocol.Version1_1},
+			ConnectionConfig: timeouts,
+		})
+	default:
+		return nil, errors.Errorf("Unknown connection type '%s', use http, http2 or vst", c.Connection)
+	}
+}
+
+// http2Transport speaks HTTP/2 over TLS for https endpoints and with prior
+// knowledge over plain TCP for http endpoints. The transport can't tell the
+// two apart per request, so the endpoints have to agree on a scheme.
+func http2Transport(endpoints []string, tlsConf *tls.Config) (nethttp.RoundTripper, error) {
+	secure := 0
+	for _, ep := range endpoints {
+		if strings.HasPrefix(util.FixupEndpointURLScheme(ep), "https://") {
+			secure++
+		}
+	}
+	if secure > 0 && secure < len(endpoints) {
+		return nil, errors.New("HTTP/2 needs all endpoints to use either http or https")
+	}
+
+	transport := &http2.Transport{TLSClientConfig: tlsConf}
+	if secure == 0 {
+		transport.AllowHTTP = true
+		transport.DialTLSContext = func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, network, addr)
+		}
+	}
+	return transport, nil
+}
+
+// retryConnection retries requests that failed for transient reasons, such as
+// a dropped connection or a 503 from a coordinator, backing off in between.
+type retryConnection struct {
+	driver.Connection
+	retries int
+	backoff time.Duration
+}
+
+// Do performs the request, retrying transient failures.
+func (c retryConnection) Do(ctx context.Context, req driver.Request) (driver.Response, error) {
+	wait := c.backoff
+	for attempt := 1; ; attempt++ {
+		resp, err := c.Connection.Do(ctx, req)
+		if attempt > c.retries || !transient(ctx, req, resp, err) {
+			return resp, err
+		}
+		log.Printf(
+			"Transient failure on %s %s, retry %d of %d in %s\n",
+			req.Method(), req.Path(), attempt, c.retries, wait,
+		)
+		select {
+		case <-ctx.Done():
+			return resp, err
+		case <-time.After(wait):
+		}
+		wait *= 2
+	}
+}
+
+// SetAuthentication keeps the retries on the authenticated copy of the connection.
+func (c retryConnection) SetAuthentication(auth driver.Authentication) (driver.Connection, error) {
+	conn, err := c.Connection.SetAuthentication(auth)
+	if e(err) {
+		return nil, err
+	}
+	return retryConnection{Connection: conn, retries: c.retries, backoff: c.backoff}, nil
+}
+
+// transient reports whether a failed request is worth another try. A 503
+// can come after a coordinator applied the write, so only reads are retried
+// on one. Network errors are also retried when the request never reached the
+// server, so a create is not applied twice.
+func transient(ctx context.Context, req driver.Request, resp driver.Response, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	read := false
+	switch req.Method() {
+	case nethttp.MethodGet, nethttp.MethodHead, nethttp.MethodOptions:
+		read = true
+	}
+	if err == nil {
+		return read && resp != nil && resp.StatusCode() == nethttp.StatusServiceUnavailable
+	}
+	if driver.IsArangoError(err) {
+		return read && driver.IsArangoErrorWithCode(err, nethttp.StatusServiceUnavailable)
+	}
+	if driver.IsCanceled(err) {
+		return false
+	}
+	return read || !req.Written()
+}
+
+// authentication picks how the client proves who it is. A JWT, either inline
+// or read from jwt_file, is sent as a bearer token; otherwise the username
+// and password are used for basic authentication.
+func authentication(c Config) (driver.Authentication, error) {
+	token, err := jwtToken(c)
+	if e(err) {
+		return nil, err
+	}
+	if token != "" {
+		return driver.RawAuthentication("bearer " + token), nil
+	}
+	return driver.BasicAuthentication(c.Username, c.Password), nil
+}
+
+// jwtToken returns the configured JWT, if any.
+func jwtToken(c Config) (string, error) {
+	if c.JWT != "" && c.JWTFile != "" {
+		return "", errors.New("Please specify only one of jwt or jwt_file in the config")
+	}
+	if c.JWTFile == "" {
+		return c.JWT, nil
+	}
+
+	bytes, err := ioutil.ReadFile(c.JWTFile)
+	if e(err) {
+		return "", errors.Wrapf(err, "Couldn't read JWT from '%s'", c.JWTFile)
+	}
+	token := strings.TrimSpace(string(bytes))
+	if token == "" {
+		return "", errors.Errorf("JWT file '%s' is empty", c.JWTFile)
+	}
+	return token, nil
+}
+
+// tlsConfig builds the TLS settings for HTTPS endpoints. The CA file replaces
+// the system roots, and the client certificate enables mutual TLS.
+func tlsConfig(c Config) (*tls.Config, error) {
+	conf := &tls.Config{
+		InsecureSkipVerify: c.SkipSslVerify,
+	}
+
+	if c.CAFile != "" {
+		pem, err := ioutil.ReadFile(c.CAFile)
+		if e(err) {
+			return nil, errors.Wrapf(err, "Couldn't read CA file '%s'", c.CAFile)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, errors.Errorf("CA file '%s' contains no PEM certificates", c.CAFile)
+		}
+		conf.RootCAs = pool
+	}
+
+	if (c.ClientCert == "") != (c.ClientKey == "") {
+		return nil, errors.New("Please specify both client_cert and client_key for mutual TLS")
+	}
+	if c.ClientCert != "" {
+		cert, err := tls.LoadX509KeyPair(c.ClientCert, c.ClientKey)
+		if e(err) {
+			return nil, errors.Wrapf(
+				err,
+				"Couldn't load client certificate '%s' with key '%s'",
+				c.ClientCert, c.ClientKey,
+			)
+		}
+		conf.Certificates = []tls.Certificate{cert}
+	}
+
+	return conf, nil
+}
@@ -0,0 +1,296 @@
+package arangomigo
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Answers the version endpoint like an ArangoDB server would, as long as the
+// request carries the expected Authorization header.
+func versionHandler(t *testing.T, wantAuth string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != wantAuth {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(`{"error":true,"code":401,"errorNum":11,"errorMessage":"not authorized"}`))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"server":"arango","version":"3.11.0","license":"community"}`))
+	}
+}
+
+// Writes the PEM blocks to a file within dir and returns its path.
+func writePEM(t *testing.T, dir, name string, blocks ...*pem.Block) string {
+	path := filepath.Join(dir, name)
+	f, err := os.Create(path)
+	if e(err) {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	for _, b := range blocks {
+		if err := pem.Encode(f, b); e(err) {
+			t.Fatal(err)
+		}
+	}
+	return path
+}
+
+// Creates a self signed client certificate and returns it with the paths of
+// its certificate and key files.
+func clientCertificate(t *testing.T, dir string) (*x509.Certificate, string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if e(err) {
+		t.Fatal(err)
+	}
+	template := x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "arangomigo"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if e(err) {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if e(err) {
+		t.Fatal(err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if e(err) {
+		t.Fatal(err)
+	}
+	certPath := writePEM(t, dir, "client.pem", &pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPath := writePEM(t, dir, "client.key", &pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer})
+	return cert, certPath, keyPath
+}
+
+// Writes the test server's certificate as the CA bundle.
+func serverCA(t *testing.T, dir string, ts *httptest.Server) string {
+	return writePEM(t, dir, "ca.pem", &pem.Block{Type: "CERTIFICATE", Bytes: ts.Certificate().Raw})
+}
+
+func TestClientJWTWithCAFile(t *testing.T) {
+	ts := httptest.NewTLSServer(versionHandler(t, "bearer my.signed.token"))
+	defer ts.Close()
+	dir := t.TempDir()
+
+	cl, err := client(Config{
+		Endpoints: []string{ts.URL},
+		JWT:       "my.signed.token",
+		CAFile:    serverCA(t, dir, ts),
+	})
+	assert.NoError(t, err)
+
+	v, err := cl.Version(context.Background())
+	assert.NoError(t, err, "Should trust the server through the CA file")
+	assert.Equal(t, "3.11.0", string(v.Version))
+}
+
+func TestClientJWTFile(t *testing.T) {
+	ts := httptest.NewTLSServer(versionHandler(t, "bearer from.a.file"))
+	defer ts.Close()
+	dir := t.TempDir()
+	jwtFile := filepath.Join(dir, "jwt")
+	if err := os.WriteFile(jwtFile, []byte("from.a.file\n"), 0600); e(err) {
+		t.Fatal(err)
+	}
+
+	cl, err := client(Config{
+		Endpoints: []string{ts.URL},
+		JWTFile:   jwtFile,
+		CAFile:    serverCA(t, dir, ts),
+	})
+	assert.NoError(t, err)
+
+	_, err = cl.Version(context.Background())
+	assert.NoError(t, err, "Should send the trimmed token from the file")
+}
+
+func TestClientMutualTLS(t *testing.T) {
+	dir := t.TempDir()
+	cert, certPath, keyPath := clientCertificate(t, dir)
+	clients := x509.NewCertPool()
+	clients.AddCert(cert)
+
+	ts := httptest.NewUnstartedServer(versionHandler(t, "bearer mtls"))
+	ts.TLS = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: clients}
+	ts.StartTLS()
+	defer ts.Close()
+
+	conf := Config{
+		Endpoints: []string{ts.URL},
+		JWT:       "mtls",
+		CAFile:    serverCA(t, dir, ts),
+	}
+	cl, err := client(conf)
+	assert.NoError(t, err)
+	_, err = cl.Version(context.Background())
+	assert.Error(t, err, "Server should reject a client without a certificate")
+
+	conf.ClientCert = certPath
+	conf.ClientKey = keyPath
+	cl, err = client(conf)
+	assert.NoError(t, err)
+	_, err = cl.Version(context.Background())
+	assert.NoError(t, err, "Server should accept the client certificate")
+}
+
+func TestClientConfigErrors(t *testing.T) {
+	_, err := client(Config{JWT: "a", JWTFile: "b"})
+	assert.EqualError(t, err, "Please specify only one of jwt or jwt_file in the config")
+
+	_, err = client(Config{ClientCert: "cert.pem"})
+	assert.EqualError(t, err, "Please specify both client_cert and client_key for mutual TLS")
+
+	_, err = client(Config{CAFile: "testdata/does_not_exist.pem"})
+	assert.Error(t, err)
+}
+
+func TestClientHTTP2(t *testing.T) {
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.ProtoMajor != 2 {
+			w.WriteHeader(http.StatusHTTPVersionNotSupported)
+			return
+		}
+		versionHandler(t, "bearer h2")(w, r)
+	}))
+	ts.EnableHTTP2 = true
+	ts.StartTLS()
+	defer ts.Close()
+
+	cl, err := client(Config{
+		Endpoints:  []string{ts.URL},
+		JWT:        "h2",
+		CAFile:     serverCA(t, t.TempDir(), ts),
+		Connection: HTTP2,
+	})
+	assert.NoError(t, err)
+	_, err = cl.Version(context.Background())
+	assert.NoError(t, err, "Should have spoken HTTP/2")
+}
+
+func TestClientUnknownConnection(t *testing.T) {
+	_, err := client(Config{Endpoints: []string{"http://localhost:8529"}, Connection: "carrier-pigeon"})
+	assert.EqualError(t, err, "Couldn't create connection to Arango\nUnknown connection type 'carrier-pigeon', use http, http2 or vst")
+
+	_, err = client(Config{
+		Endpoints:  []string{"http://localhost:8529", "https://localhost:8530"},
+		Connection: HTTP2,
+	})
+	assert.EqualError(t, err, "Couldn't create connection to Arango\nHTTP/2 needs all endpoints to use either http or https")
+}
+
+func TestClientRetriesUnavailable(t *testing.T) {
+	calls := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if calls < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		versionHandler(t, "bearer retry")(w, r)
+	}))
+	defer ts.Close()
+
+	conf := Config{
+		Endpoints:    []string{ts.URL},
+		JWT:          "retry",
+		Retries:      1,
+		RetryBackoff: time.Millisecond,
+	}
+	cl, err := client(conf)
+	assert.NoError(t, err)
+	_, err = cl.Version(context.Background())
+	assert.Error(t, err, "One retry isn't enough to get past two failures")
+
+	calls = 0
+	conf.Retries = 3
+	cl, err = client(conf)
+	assert.NoError(t, err)
+	_, err = cl.Version(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, 3, calls, "Should have stopped retrying after the success")
+}
+
+func TestClientDoesntRetryUnavailableWrites(t *testing.T) {
+	calls := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte(`{"error":true,"code":503,"errorNum":1,"errorMessage":"unavailable"}`))
+	}))
+	defer ts.Close()
+
+	cl, err := client(Config{Endpoints: []string{ts.URL}, Retries: 3, RetryBackoff: time.Millisecond})
+	assert.NoError(t, err)
+	_, err = cl.CreateDatabase(context.Background(), "Shop", nil)
+	assert.Error(t, err)
+	assert.Equal(t, 1, calls, "The write may have been applied before the 503")
+}
+
+func TestClientSynchronizeEndpointsTimeout(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(200 * time.Millisecond)
+	}))
+	defer ts.Close()
+
+	start := time.Now()
+	_, err := client(Config{
+		Endpoints:            []string{ts.URL},
+		RequestTimeout:       20 * time.Millisecond,
+		SynchronizeEndpoints: true,
+	})
+	assert.Error(t, err)
+	assert.True(t, time.Since(start) < 200*time.Millisecond, "Should have given up after the request timeout")
+}
+
+func TestClientRequestTimeout(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(200 * time.Millisecond)
+		versionHandler(t, "bearer slow")(w, r)
+	}))
+	defer ts.Close()
+
+	cl, err := client(Config{
+		Endpoints:      []string{ts.URL},
+		JWT:            "slow",
+		RequestTimeout: 20 * time.Millisecond,
+	})
+	assert.NoError(t, err)
+	_, err = cl.Version(context.Background())
+	assert.Error(t, err, "Should have timed out")
+}
+
+func TestMigrateWithin(t *testing.T) {
+	err := migrateWithin(context.Background(), 10*time.Millisecond, func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	assert.EqualError(t, err, "Migration exceeded the timeout of 10ms: context deadline exceeded")
+
+	err = migrateWithin(context.Background(), 0, func(ctx context.Context) error {
+		_, hasDeadline := ctx.Deadline()
+		assert.False(t, hasDeadline, "No timeout means no deadline")
+		return nil
+	})
+	assert.NoError(t, err)
+}
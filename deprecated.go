@@ -0,0 +1,289 @@
+package arangomigo
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v2"
+)
+
+// deprecation describes an index type the server only keeps for old
+// migrations.
+type deprecation struct {
+	kind string
+	// since is the first server version that deprecates the type.
+	since string
+	// instead is what to migrate to.
+	instead string
+}
+
+// deprecationOf finds whether the migration builds a deprecated index type.
+// Deleting one is how to get rid of it, so that's never deprecated.
+func deprecationOf(m Migration) (deprecation, bool) {
+	switch t := m.(type) {
+	case *HashIndex:
+		return deprecation{"hashindex", "3.7", "a persistentindex"}, t.Action != DELETE
+	case *SkiplistIndex:
+		return deprecation{"skiplistindex", "3.7", "a persistentindex"}, t.Action != DELETE
+	case *FullTextIndex:
+		return deprecation{"fulltextindex", "3.10", "an invertedindex with a text analyzer"}, t.Action != DELETE
+	}
+	return deprecation{}, false
+}
+
+// deprecations lists the migrations using index types the server version
+// deprecates.
+func deprecations(version string, pms []PairedMigrations) []string {
+	var warnings []string
+	for _, pm := range pms {
+		d, ok := deprecationOf(pm.change)
+		if !ok {
+			continue
+		}
+		if met, _ := satisfies(version, ">="+d.since); !met {
+			continue
+		}
+		warnings = append(warnings, fmt.Sprintf(
+			"%s uses a %s, deprecated since ArangoDB %s. Use %s instead, arangomigo convert rewrites it",
+			pm.change.FileName(), d.kind, d.since, d.instead,
+		))
+	}
+	return warnings
+}
+
+// ConvertDeprecated rewrites the migrations under the config's paths that
+// use deprecated index types.
+func ConvertDeprecated(configAt string) {
+	config, err := loadConf(configAt)
+	if e(err) {
+		log.Fatal(err)
+	}
+
+	written, err := convertDeprecated(config.MigrationsPath)
+	if e(err) {
+		log.Fatal("Could not convert the deprecated indexes\n", err)
+	}
+	log.Printf("Rewrote %d migration files\n", len(written))
+}
+
+// convertDeprecated rewrites hash and skiplist indexes as persistent ones and
+// fulltext indexes as inverted ones, keeping their names, returning the files
+// it wrote. Converted files keep their names, so databases that already ran
+// them don't run them again, and the original contents as a comment.
+func convertDeprecated(paths []string) ([]string, error) {
+	var written []string
+	for _, path := range paths {
+		files, err := filepath.Glob(filepath.Join(path, "*.migration"))
+		if e(err) {
+			return written, err
+		}
+		for _, file := range files {
+			wrote, err := convertFile(file)
+			if e(err) {
+				return written, errors.Wrapf(err, "Couldn't convert %s", file)
+			}
+			written = append(written, wrote...)
+		}
+	}
+	return written, nil
+}
+
+func convertFile(file string) ([]string, error) {
+	contents, _, err := open(file)
+	if e(err) {
+		return nil, err
+	}
+	m, err := pickT(contents)
+	if e(err) {
+		return nil, err
+	}
+	switch m.(type) {
+	case *HashIndex, *SkiplistIndex, *FullTextIndex:
+	default:
+		return nil, nil
+	}
+	if err := yaml.UnmarshalStrict(contents, m); e(err) {
+		return nil, err
+	}
+
+	switch t := m.(type) {
+	case *HashIndex:
+		return []string{file}, writeMigration(file, persistentFrom(t.Operation, t.Fields, t.Collection,
+			t.Unique, t.Sparse, t.NoDeduplicate, t.InBackground, t.Existing), t.Type, contents)
+	case *SkiplistIndex:
+		return []string{file}, writeMigration(file, persistentFrom(t.Operation, t.Fields, t.Collection,
+			t.Unique, t.Sparse, t.NoDeduplicate, t.InBackground, t.Existing), t.Type, contents)
+	}
+
+	full := m.(*FullTextIndex)
+	if full.Action == DELETE {
+		return []string{file}, writeMigration(file, invertedFrom(full, ""), full.Type, contents)
+	}
+
+	// The analyzer has to exist before the index, so it goes in a file that
+	// sorts just ahead of this one.
+	base := filepath.Base(file)
+	if !strings.Contains(base, "_") {
+		return nil, errors.Errorf(
+			"The analyzer needs a file next to it, rename it to %s_<description>.migration first",
+			strings.TrimSuffix(base, ".migration"),
+		)
+	}
+	analyzerFile := analyzerFileFor(file)
+	if _, err := os.Stat(analyzerFile); err == nil {
+		return nil, errors.Errorf("%s already exists", analyzerFile)
+	}
+	name := full.Name
+	if name == "" {
+		name = full.Collection
+	}
+	name += "_text"
+	if err := writeMigration(analyzerFile, textAnalyzerFrom(full, name), full.Type, nil); e(err) {
+		return nil, err
+	}
+	if full.MinLength > 0 {
+		log.Printf(
+			"%s: text analyzers have no minimum word length, so words shorter than %d are indexed too\n",
+			file, full.MinLength,
+		)
+	}
+	log.Printf(
+		"%s: databases that already ran it keep the fulltext index and only get analyzer '%s', "+
+			"migrating them logs which ones need another migration for the inverted index\n",
+		file, name,
+	)
+	return []string{analyzerFile, file}, writeMigration(file, invertedFrom(full, name), full.Type, contents)
+}
+
+// analyzerFileFor names the file holding the analyzer a converted fulltext
+// index uses.
+func analyzerFileFor(file string) string {
+	return strings.TrimSuffix(file, ".migration") + ".analyzer.migration"
+}
+
+// missedConversion warns when a database already ran a fulltext migration
+// before it was converted. The analyzer file is new, so it runs, but the
+// inverted index in the old file never will.
+func missedConversion(db string, m Migration, ranNow map[string]bool) {
+	i, ok := m.(*InvertedIndex)
+	if !ok || !ranNow[analyzerFileFor(m.FileName())] {
+		return
+	}
+	log.Printf(
+		"Warning: database %s ran %s before it was converted, so it still has the fulltext index rather than "+
+			"inverted index '%s'. Add a migration that builds it there\n",
+		db, m.FileName(), i.Name,
+	)
+}
+
+// operation is the part of the YAML every migration shares. The type has to
+// come first for pickT.
+func operation(op Operation, kind string) yaml.MapSlice {
+	out := yaml.MapSlice{{Key: "type", Value: kind}, {Key: "action", Value: op.Action}}
+	if op.Name != "" {
+		out = append(out, yaml.MapItem{Key: "name", Value: op.Name})
+	}
+	if op.Database != "" {
+		out = append(out, yaml.MapItem{Key: "database", Value: op.Database})
+	}
+	if r := op.Requires; r != nil {
+		var requires yaml.MapSlice
+		for _, item := range []yaml.MapItem{
+			{Key: "server", Value: r.Server},
+			{Key: "edition", Value: r.Edition},
+			{Key: "deployment", Value: r.Deployment},
+		} {
+			if item.Value != "" {
+				requires = append(requires, item)
+			}
+		}
+		out = append(out, yaml.MapItem{Key: "requires", Value: requires})
+	}
+	return out
+}
+
+func persistentFrom(
+	op Operation,
+	fields []string,
+	collection string,
+	unique, sparse, noDeduplicate, inBackground bool,
+	existing string,
+) yaml.MapSlice {
+	out := append(operation(op, "persistentindex"),
+		yaml.MapItem{Key: "collection", Value: collection},
+		yaml.MapItem{Key: "fields", Value: fields},
+	)
+	if unique {
+		out = append(out, yaml.MapItem{Key: "unique", Value: true})
+	}
+	if sparse {
+		out = append(out, yaml.MapItem{Key: "sparse", Value: true})
+	}
+	if noDeduplicate {
+		out = append(out, yaml.MapItem{Key: "deduplicate", Value: false})
+	}
+	if inBackground {
+		out = append(out, yaml.MapItem{Key: "inbackground", Value: true})
+	}
+	if existing != "" {
+		out = append(out, yaml.MapItem{Key: "existing", Value: existing})
+	}
+	return out
+}
+
+func invertedFrom(i *FullTextIndex, analyzer string) yaml.MapSlice {
+	out := append(operation(i.Operation, "invertedindex"), yaml.MapItem{Key: "collection", Value: i.Collection})
+	if i.Action == DELETE {
+		return out
+	}
+	out = append(out,
+		yaml.MapItem{Key: "fields", Value: i.Fields},
+		yaml.MapItem{Key: "analyzer", Value: analyzer},
+	)
+	if i.InBackground {
+		out = append(out, yaml.MapItem{Key: "inbackground", Value: true})
+	}
+	if i.Existing != "" {
+		out = append(out, yaml.MapItem{Key: "existing", Value: i.Existing})
+	}
+	return out
+}
+
+// textAnalyzerFrom splits words and lowercases them the way a fulltext index
+// does, without stemming or stop words.
+func textAnalyzerFrom(i *FullTextIndex, name string) yaml.MapSlice {
+	op := Operation{Name: name, Action: CREATE, Database: i.Database}
+	return append(operation(op, "analyzer"),
+		yaml.MapItem{Key: "analyzerType", Value: "text"},
+		yaml.MapItem{Key: "properties", Value: yaml.MapSlice{
+			{Key: "locale", Value: "en"},
+			{Key: "case", Value: "lower"},
+			{Key: "accent", Value: true},
+			{Key: "stemming", Value: false},
+			{Key: "stopwords", Value: []string{}},
+		}},
+		yaml.MapItem{Key: "features", Value: []string{"frequency", "norm", "position"}},
+	)
+}
+
+// writeMigration keeps the original contents, comments and all, commented out
+// below the converted migration.
+func writeMigration(file string, m yaml.MapSlice, from string, original []byte) error {
+	out, err := yaml.Marshal(m)
+	if e(err) {
+		return err
+	}
+	out = append(out, fmt.Sprintf("# Converted from a %s by arangomigo convert\n", from)...)
+	if len(original) > 0 {
+		out = append(out, "# The original was:\n"...)
+		for _, line := range strings.Split(strings.TrimRight(string(original), "\n"), "\n") {
+			out = append(out, strings.TrimRight("# "+line, " ")+"\n"...)
+		}
+	}
+	return ioutil.WriteFile(file, out, 0644)
+}
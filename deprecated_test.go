@@ -0,0 +1,152 @@
+package arangomigo
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDeprecations(t *testing.T) {
+	pms := []PairedMigrations{
+		{change: parseMigration(t, "type: hashindex\naction: create\ncollection: c\nfields:\n  - a\n")},
+		{change: parseMigration(t, "type: fulltextindex\naction: create\ncollection: c\nfields:\n  - a\n")},
+		{change: parseMigration(t, "type: skiplistindex\naction: delete\nname: old\ncollection: c\n")},
+		{change: parseMigration(t, "type: persistentindex\naction: create\ncollection: c\nfields:\n  - a\n")},
+	}
+	pms[0].change.SetFileName("1_hash.migration")
+	pms[1].change.SetFileName("2_fulltext.migration")
+
+	assert.Empty(t, deprecations("3.6.4", pms))
+	assert.Equal(t, []string{
+		"1_hash.migration uses a hashindex, deprecated since ArangoDB 3.7. " +
+			"Use a persistentindex instead, arangomigo convert rewrites it",
+	}, deprecations("3.9.1", pms))
+	assert.Len(t, deprecations("3.12.4", pms), 2)
+}
+
+func TestConvertDeprecated(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"1_users.migration": "type: collection\naction: create\nname: users\n",
+		"2_by_email.migration": "type: hashindex\naction: create\nname: byEmail\ncollection: users\n" +
+			"# Logins look users up by email\nfields:\n  - email\nunique: true\nnodeduplicate: true\n",
+		"3_by_age.migration": "type: skiplistindex\naction: create\ncollection: users\nfields:\n  - age\n" +
+			"requires:\n  server: \">=3.5\"\n",
+		"4_bios.migration": "type: fulltextindex\naction: create\nname: bios\ncollection: users\n" +
+			"fields:\n  - bio\nminlength: 3\n",
+		"5_drop_bios.migration": "type: fulltextindex\naction: delete\nname: bios\ncollection: users\n",
+	}
+	for name, contents := range files {
+		assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, name), []byte(contents), 0644))
+	}
+
+	written, err := convertDeprecated([]string{dir})
+	assert.NoError(t, err)
+	assert.Len(t, written, 5)
+
+	ms, err := loadFrom(dir)
+	assert.NoError(t, err)
+	var names []string
+	for _, m := range ms {
+		names = append(names, m.FileName())
+	}
+	assert.Equal(t, []string{
+		"1_users.migration",
+		"2_by_email.migration",
+		"3_by_age.migration",
+		"4_bios.analyzer.migration",
+		"4_bios.migration",
+		"5_drop_bios.migration",
+	}, names)
+
+	byEmail := ms[1].(*PersistentIndex)
+	assert.Equal(t, "byEmail", byEmail.Name)
+	assert.Equal(t, []string{"email"}, byEmail.Fields)
+	assert.True(t, byEmail.Unique)
+	assert.False(t, *byEmail.Deduplicate)
+
+	byAge := ms[2].(*PersistentIndex)
+	assert.Equal(t, "", byAge.Name)
+	assert.Equal(t, Requirements{Server: ">=3.5"}, *byAge.Requires)
+
+	analyzer := ms[3].(*Analyzer)
+	assert.Equal(t, "bios_text", analyzer.Name)
+	assert.Equal(t, "text", analyzer.AnalyzerType)
+	assert.NoError(t, analyzer.Validate())
+	// An empty list turns Arango's default stop words off, where leaving it
+	// out would keep them.
+	emitted, err := ioutil.ReadFile(filepath.Join(dir, "4_bios.analyzer.migration"))
+	assert.NoError(t, err)
+	assert.Contains(t, string(emitted), "\n  stemming: false\n  stopwords: []\n")
+	sent, err := json.Marshal(analyzer.Properties)
+	assert.NoError(t, err)
+	assert.Contains(t, string(sent), `"stopwords":[]`)
+
+	bios := ms[4].(*InvertedIndex)
+	assert.Equal(t, "bios", bios.Name)
+	assert.Equal(t, "bios_text", bios.Analyzer)
+	assert.Equal(t, []InvertedField{{Name: "bio"}}, bios.Fields)
+	assert.NoError(t, bios.Validate())
+
+	drop := ms[5].(*InvertedIndex)
+	assert.Equal(t, DELETE, drop.Action)
+	assert.Equal(t, "bios", drop.Name)
+
+	// The original is kept, comments and all.
+	converted, err := ioutil.ReadFile(filepath.Join(dir, "2_by_email.migration"))
+	assert.NoError(t, err)
+	assert.Contains(t, string(converted), "# Converted from a hashindex by arangomigo convert\n"+
+		"# The original was:\n"+
+		"# type: hashindex\n# action: create\n# name: byEmail\n# collection: users\n"+
+		"# # Logins look users up by email\n# fields:\n#   - email\n# unique: true\n# nodeduplicate: true\n")
+
+	// Nothing is left to convert the second time.
+	written, err = convertDeprecated([]string{dir})
+	assert.NoError(t, err)
+	assert.Empty(t, written)
+}
+
+func TestConvertedFulltextAlreadyRun(t *testing.T) {
+	dir := t.TempDir()
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "4_bios.migration"), []byte(
+		"type: fulltextindex\naction: create\nname: bios\ncollection: users\nfields:\n  - bio\n"), 0644))
+	_, err := convertDeprecated([]string{dir})
+	assert.NoError(t, err)
+	ms, err := loadFrom(dir)
+	assert.NoError(t, err)
+	var pms []PairedMigrations
+	for _, m := range ms {
+		pms = append(pms, PairedMigrations{change: m})
+	}
+
+	ts, changes := recordingArango(t, map[string]reply{
+		"GET /_db/Shop/_api/database/current":                      okReply(`{"result":{"name":"Shop"}}`),
+		"GET /_db/Shop/_api/collection/arangomigo":                 okReply(`{"name":"arangomigo"}`),
+		"HEAD /_db/Shop/_api/document/arangomigo/4_bios.migration": okReply(``),
+		"POST /_db/Shop/_api/analyzer":                             {status: http.StatusCreated, body: `{"name":"Shop::bios_text","type":"text"}`},
+		"POST /_db/Shop/_api/document/arangomigo":                  {status: http.StatusAccepted, body: `{"_key":"4_bios.analyzer.migration"}`},
+	})
+	defer ts.Close()
+	cl, err := client(Config{Endpoints: []string{ts.URL}})
+	assert.NoError(t, err)
+	db, err := cl.Database(context.Background(), "Shop")
+	assert.NoError(t, err)
+
+	var logged bytes.Buffer
+	log.SetOutput(&logged)
+	defer log.SetOutput(os.Stderr)
+	ran, err := migrateNow(context.Background(), Config{}, cl, db, pms)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, ran)
+	assert.Len(t, *changes, 2)
+	assert.Contains(t, logged.String(), "Warning: database Shop ran 4_bios.migration before it was converted, "+
+		"so it still has the fulltext index rather than inverted index 'bios'. Add a migration that builds it there")
+}
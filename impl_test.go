@@ -2,7 +2,17 @@ package arangomigo
 
 import (
 	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
 	driver "github.com/arangodb/go-driver"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v2"
 )
 
 type MockDB struct {
@@ -23,3 +33,743 @@ type MockCol struct {
 func (mc MockCol) Name() string {
 	return mc.name
 }
+
+// Records the options of every collection it's asked to create or update.
+type CollectionRecorder struct {
+	MockDB
+	created map[string]driver.CreateCollectionOptions
+	updated map[string]driver.SetCollectionPropertiesOptions
+	// Queries the server would fail to parse.
+	invalid   map[string]bool
+	renamed   map[string]string
+	truncated []string
+}
+
+func (cr *CollectionRecorder) ValidateQuery(ctx context.Context, query string) error {
+	if cr.invalid[query] {
+		return driver.ArangoError{HasError: true, Code: 400, ErrorNum: 1501, ErrorMessage: "syntax error"}
+	}
+	return nil
+}
+
+func (cr *CollectionRecorder) Collection(ctx context.Context, name string) (driver.Collection, error) {
+	return RecordingCol{MockCol: MockCol{name: name}, recorder: cr}, nil
+}
+
+// Hands the properties it's given to the recorder.
+type RecordingCol struct {
+	MockCol
+	recorder *CollectionRecorder
+}
+
+func (rc RecordingCol) SetProperties(ctx context.Context, options driver.SetCollectionPropertiesOptions) error {
+	if rc.recorder.updated == nil {
+		rc.recorder.updated = map[string]driver.SetCollectionPropertiesOptions{}
+	}
+	rc.recorder.updated[rc.name] = options
+	return nil
+}
+
+func (rc RecordingCol) Rename(ctx context.Context, newName string) error {
+	if rc.recorder.renamed == nil {
+		rc.recorder.renamed = map[string]string{}
+	}
+	rc.recorder.renamed[rc.name] = newName
+	return nil
+}
+
+func (rc RecordingCol) Truncate(ctx context.Context) error {
+	rc.recorder.truncated = append(rc.recorder.truncated, rc.name)
+	return nil
+}
+
+func (cr *CollectionRecorder) CreateCollection(ctx context.Context, name string, options *driver.CreateCollectionOptions) (driver.Collection, error) {
+	if cr.created == nil {
+		cr.created = map[string]driver.CreateCollectionOptions{}
+	}
+	cr.created[name] = *options
+	return MockCol{name: name}, nil
+}
+
+// Parses the YAML the same way migration files are.
+func parseMigration(t *testing.T, contents string) Migration {
+	m, err := pickT([]byte(contents))
+	if e(err) {
+		t.Fatal(err)
+	}
+	if err := yaml.UnmarshalStrict([]byte(contents), m); e(err) {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func TestCollectionClusterOptions(t *testing.T) {
+	m := parseMigration(t, `type: collection
+action: create
+name: orders
+numberofshards: 6
+replicationfactor: 3
+writeconcern: 2
+shardingstrategy: hash
+cacheenabled: true
+`)
+	assert.NoError(t, m.(Validator).Validate())
+
+	db := &CollectionRecorder{}
+	assert.NoError(t, m.Migrate(context.Background(), db, nil))
+	options := db.created["orders"]
+	assert.Equal(t, 6, options.NumberOfShards)
+	assert.Equal(t, 3, options.ReplicationFactor)
+	assert.Equal(t, 2, options.WriteConcern)
+	assert.Equal(t, driver.ShardingStrategyHash, options.ShardingStrategy)
+	assert.True(t, *options.CacheEnabled)
+}
+
+func TestCollectionSatellite(t *testing.T) {
+	m := parseMigration(t, `type: collection
+action: create
+name: countries
+replicationfactor: satellite
+`)
+	assert.NoError(t, m.(Validator).Validate())
+
+	db := &CollectionRecorder{}
+	assert.NoError(t, m.Migrate(context.Background(), db, nil))
+	assert.Equal(t, driver.ReplicationFactorSatellite, db.created["countries"].ReplicationFactor)
+
+	err := yaml.UnmarshalStrict([]byte("type: collection\nreplicationfactor: lots\n"), &Collection{})
+	assert.EqualError(t, err, "replicationfactor must be a number or satellite, not 'lots'")
+}
+
+func TestCollectionClusterValidation(t *testing.T) {
+	invalid := map[string]string{
+		"numberofshards: 0":                               "Collection 'c' needs at least 1 shard",
+		"replicationfactor: 0":                            "Collection 'c' needs a replicationfactor of at least 1 or satellite",
+		"replicationfactor: satellite\nnumberofshards: 3": "Satellite collection 'c' always has 1 shard",
+		"replicationfactor: satellite\nwriteconcern: 1":   "Satellite collection 'c' can't set a writeconcern",
+		"replicationfactor: 2\nwriteconcern: 3":           "Collection 'c' has a writeconcern of 3, more than its replicationfactor of 2",
+		"distributeshardslike: p\nnumberofshards: 3":      "Collection 'c' takes its shards from 'p', so it can't set numberofshards or replicationfactor",
+		"shardingstrategy: random":                        "Collection 'c' has unknown shardingstrategy 'random'",
+		"smartjoinattribute: productId":                   "Collection 'c' uses smartjoinattribute, so it needs a single shard key ending in ':', such as '_key:'",
+	}
+	for options, msg := range invalid {
+		m := parseMigration(t, "type: collection\naction: create\nname: c\n"+options+"\n")
+		assert.EqualError(t, m.(Validator).Validate(), msg)
+	}
+}
+
+func TestCollectionSchemaFile(t *testing.T) {
+	path := "testdata/schema/1_recipes.migration"
+	m, err := toStruct(path)
+	assert.NoError(t, err)
+	assert.NoError(t, m.(Validator).Validate())
+
+	_, plain, _ := open(path)
+	assert.NotEqual(t, plain, m.CheckSum(), "The rule file should count towards the checksum")
+
+	db := &CollectionRecorder{}
+	assert.NoError(t, m.Migrate(context.Background(), db, nil))
+	schema := db.created["recipes"].Schema
+	assert.Equal(t, driver.CollectionSchemaLevelModerate, schema.Level)
+	assert.Equal(t, "Recipes need a name", schema.Message)
+	assert.Equal(t, []interface{}{"name"}, schema.Rule.(map[string]interface{})["required"])
+}
+
+func TestCollectionSchemaInlineModify(t *testing.T) {
+	m := parseMigration(t, `type: collection
+action: modify
+name: recipes
+schema:
+  rule:
+    type: object
+    properties:
+      name:
+        type: string
+    required:
+      - name
+  level: strict
+`)
+	assert.NoError(t, m.(Validator).Validate())
+
+	db := &CollectionRecorder{}
+	assert.NoError(t, m.Migrate(context.Background(), db, nil))
+	schema := db.updated["recipes"].Schema
+	assert.Equal(t, driver.CollectionSchemaLevelStrict, schema.Level)
+	rule := schema.Rule.(map[string]interface{})
+	assert.Equal(t, "string", rule["properties"].(map[string]interface{})["name"].(map[string]interface{})["type"])
+}
+
+func TestCollectionSchemaValidation(t *testing.T) {
+	invalid := map[string]string{
+		"schema:\n  level: strict":                                  "Collection 'c' schema needs a rule or rulefile",
+		"schema:\n  rulefile: rule.yaml":                            "Collection 'c' schema rulefile 'rule.yaml' must be a .json file",
+		"schema:\n  rulefile: rule.json\n  rule:\n    type: object": "Collection 'c' schema has both a rule and a rulefile, pick one",
+		"schema:\n  rule:\n    type: object\n  level: always":       "Collection 'c' schema has unknown level 'always', use none, new, moderate or strict",
+	}
+	for options, msg := range invalid {
+		m := parseMigration(t, "type: collection\naction: modify\nname: c\n"+options+"\n")
+		assert.EqualError(t, m.(Validator).Validate(), msg)
+	}
+}
+
+const computedCollection = `type: collection
+action: %s
+name: recipes
+computedValues:
+  - name: createdAt
+    expression: RETURN DATE_NOW()
+    computeOn:
+      - insert
+    overwrite: true
+  - name: searchName
+    expression: RETURN LOWER(@doc.name)
+    keepNull: false
+    failOnWarning: true
+`
+
+func TestCollectionComputedValues(t *testing.T) {
+	for _, action := range []string{"create", "modify"} {
+		m := parseMigration(t, fmt.Sprintf(computedCollection, action))
+		assert.NoError(t, m.(Validator).Validate())
+		assert.Contains(t, requirementsOf(m), Requirements{Server: ">=3.10"})
+
+		db := &CollectionRecorder{}
+		assert.NoError(t, m.Migrate(context.Background(), db, nil))
+		values := db.created["recipes"].ComputedValues
+		if action == "modify" {
+			values = db.updated["recipes"].ComputedValues
+		}
+		f := false
+		tr := true
+		assert.Equal(t, []driver.ComputedValue{
+			{Name: "createdAt", Expression: "RETURN DATE_NOW()", ComputeOn: []driver.ComputeOn{driver.ComputeOnInsert}, Overwrite: true},
+			{Name: "searchName", Expression: "RETURN LOWER(@doc.name)", KeepNull: &f, FailOnWarning: &tr},
+		}, values, action)
+	}
+}
+
+func TestCollectionComputedValuesParsed(t *testing.T) {
+	m := parseMigration(t, fmt.Sprintf(computedCollection, "create"))
+	db := &CollectionRecorder{invalid: map[string]bool{"RETURN LOWER(@doc.name)": true}}
+	err := m.Migrate(context.Background(), db, nil)
+	assert.Contains(t, err.Error(), "Computed value 'searchName' on collection 'recipes' has an invalid expression")
+	assert.Empty(t, db.created, "Nothing should be created when an expression doesn't parse")
+}
+
+func TestCollectionComputedValuesValidation(t *testing.T) {
+	invalid := map[string]string{
+		"- expression: RETURN 1":                 "Collection 'c' has a computed value without a name",
+		"- name: _key\n    expression: RETURN 1": "Collection 'c' can't compute the system attribute '_key'",
+		"- name: a\n    expression: RETURN 1\n  - name: a\n    expression: RETURN 2": "Collection 'c' computes 'a' more than once",
+		"- name: a": "Computed value 'a' on collection 'c' needs an expression",
+		"- name: a\n    expression: RETURN 1\n    computeOn: [remove]": "Computed value 'a' on collection 'c' has unknown computeOn 'remove', use insert, update or replace",
+	}
+	for values, msg := range invalid {
+		m := parseMigration(t, "type: collection\naction: modify\nname: c\ncomputedValues:\n  "+values+"\n")
+		assert.EqualError(t, m.(Validator).Validate(), msg)
+	}
+
+	m := parseMigration(t, "type: collection\naction: create\nname: c\nshardkeys: [region]\ncomputedValues:\n  - name: region\n    expression: RETURN 1\n")
+	assert.EqualError(t, m.(Validator).Validate(), "Collection 'c' can't compute its shard key 'region'")
+}
+
+func TestCollectionModifyProperties(t *testing.T) {
+	m := parseMigration(t, `type: collection
+action: modify
+name: orders
+waitforsync: true
+replicationfactor: 3
+writeconcern: 2
+cacheenabled: true
+`)
+	assert.NoError(t, m.(Validator).Validate())
+
+	db := &CollectionRecorder{}
+	assert.NoError(t, m.Migrate(context.Background(), db, nil))
+	options := db.updated["orders"]
+	assert.Equal(t, 3, options.ReplicationFactor)
+	assert.Equal(t, 2, options.WriteConcern)
+	assert.True(t, *options.CacheEnabled)
+	assert.True(t, *options.WaitForSync)
+}
+
+func TestCollectionModifyRejectsFixedProperties(t *testing.T) {
+	invalid := map[string]string{
+		"shardkeys: [region]":                       "Collection 'c' can't change shardkeys after it's created",
+		"keygeneratortype: uuid\nallowuserkeys: no": "Collection 'c' can't change allowuserkeys, keygeneratortype after it's created",
+		"numberofshards: 3":                         "Collection 'c' can't change numberofshards after it's created",
+		"collectiontype: edge":                      "Collection 'c' can't change collectiontype after it's created",
+		"replicationfactor: satellite":              "Collection 'c' can't become a satellite after it's created",
+		"replicationfactor: 2\nwriteconcern: 3":     "Collection 'c' has a writeconcern of 3, more than its replicationfactor of 2",
+	}
+	for options, msg := range invalid {
+		m := parseMigration(t, "type: collection\naction: modify\nname: c\n"+options+"\n")
+		assert.EqualError(t, m.(Validator).Validate(), msg)
+	}
+}
+
+func TestCollectionRenameAndTruncate(t *testing.T) {
+	db := &CollectionRecorder{}
+	rename := parseMigration(t, "type: collection\naction: rename\nname: recipes\nnewName: dishes\n")
+	assert.NoError(t, rename.Migrate(context.Background(), db, nil))
+	truncate := parseMigration(t, "type: collection\naction: truncate\nname: scratch\n")
+	assert.NoError(t, truncate.Migrate(context.Background(), db, nil))
+
+	assert.Equal(t, map[string]string{"recipes": "dishes"}, db.renamed)
+	assert.Equal(t, []string{"scratch"}, db.truncated)
+}
+
+func TestCollectionAutoincrementKeys(t *testing.T) {
+	m, err := toStruct("testdata/key_generator_type/3_autoincrement.migration")
+	assert.NoError(t, err)
+	assert.NoError(t, m.(Validator).Validate())
+	assert.Contains(t, requirementsOf(m), Requirements{Deployment: SingleServer})
+
+	db := &CollectionRecorder{}
+	assert.NoError(t, m.Migrate(context.Background(), db, nil))
+	ko := db.created["tickets"].KeyOptions
+	assert.Equal(t, driver.KeyGeneratorAutoIncrement, ko.Type)
+	assert.Equal(t, 10, ko.Increment)
+	assert.Equal(t, 1000, ko.Offset)
+}
+
+func TestCollectionKeyGeneratorValidation(t *testing.T) {
+	invalid := map[string]string{
+		"keygeneratortype: random":                           "Collection 'c' has unknown keygeneratortype 'random', use traditional, autoincrement, uuid or padded",
+		"keygeneratortype: uuid\nincrement: 2":               "Collection 'c' sets increment or offset, which only the autoincrement keygeneratortype uses",
+		"offset: 5":                                          "Collection 'c' sets increment or offset, which only the autoincrement keygeneratortype uses",
+		"keygeneratortype: autoincrement\nincrement: 0":      "Collection 'c' needs an increment between 1 and 65535",
+		"keygeneratortype: autoincrement\noffset: -1":        "Collection 'c' can't have a negative offset",
+		"keygeneratortype: autoincrement\nnumberofshards: 3": "Collection 'c' uses autoincrement keys, so it can only have 1 shard",
+	}
+	for options, msg := range invalid {
+		m := parseMigration(t, "type: collection\naction: create\nname: c\n"+options+"\n")
+		assert.EqualError(t, m.(Validator).Validate(), msg)
+	}
+}
+
+func TestDatabaseClusterDefaults(t *testing.T) {
+	ts, changes := recordingArango(t, map[string]reply{
+		"POST /_db/_system/_api/database": {status: http.StatusCreated, body: `{"result":true}`},
+	})
+	defer ts.Close()
+	cl, err := client(Config{Endpoints: []string{ts.URL}})
+	assert.NoError(t, err)
+
+	m := parseMigration(t, `type: database
+action: create
+name: Tenant
+sharding: single
+replicationfactor: 3
+writeconcern: 2
+allowed:
+  - username: tenant
+    password: ${tenantPassword}
+`)
+	assert.NoError(t, m.(Validator).Validate())
+	m.(clientMigration).setClient(cl)
+	assert.NoError(t, m.Migrate(context.Background(), nil, map[string]interface{}{"${tenantPassword}": "pw"}))
+	assert.Equal(t, []string{
+		`POST /_db/_system/_api/database {"users":[{"user":"tenant","passwd":"pw","active":true}],` +
+			`"options":{"replicationFactor":3,"writeConcern":2,"sharding":"single"},"name":"Tenant"}`,
+	}, *changes)
+}
+
+func TestDatabaseClusterValidation(t *testing.T) {
+	invalid := map[string]string{
+		"action: create\nsharding: many":                        "Database 'd' has unknown sharding 'many', use single or flexible",
+		"action: create\nreplicationfactor: satellite":          "Database 'd' needs a replicationfactor of at least 1",
+		"action: create\nwriteconcern: 0":                       "Database 'd' needs a writeconcern of at least 1",
+		"action: create\nreplicationfactor: 1\nwriteconcern: 2": "Database 'd' has a writeconcern of 2, more than its replicationfactor of 1",
+		"action: delete\nsharding: single":                      "Database 'd' can only set sharding, replicationfactor and writeconcern when it's created",
+	}
+	for options, msg := range invalid {
+		m := parseMigration(t, "type: database\nname: d\n"+options+"\n")
+		assert.EqualError(t, m.(Validator).Validate(), msg)
+	}
+}
+
+func TestDatabaseModifyUsers(t *testing.T) {
+	ts, changes := recordingArango(t, map[string]reply{
+		"GET /_db/Shop/_api/database/current":   okReply(`{"result":{"name":"Shop"}}`),
+		"GET /_api/user/existing":               okReply(`{"user":"existing","active":true}`),
+		"POST /_api/user":                       {status: http.StatusCreated, body: `{"user":"newbie","active":true}`},
+		"PUT /_api/user/existing/database/Shop": okReply(`{}`),
+		"PUT /_api/user/newbie/database/Shop":   okReply(`{}`),
+		"GET /_api/user/intern":                 okReply(`{"user":"intern","active":true}`),
+		"PUT /_api/user/intern/database/Shop":   okReply(`{}`),
+	})
+	defer ts.Close()
+	cl, err := client(Config{Endpoints: []string{ts.URL}})
+	assert.NoError(t, err)
+	db, err := cl.Database(context.Background(), "Shop")
+	assert.NoError(t, err)
+
+	m := parseMigration(t, `type: database
+action: modify
+name: Shop
+allowed:
+  - username: existing
+    password: ignored
+  - username: newbie
+    password: ${newbiePassword}
+disallowed:
+  - intern
+  - ghost
+`)
+	assert.NoError(t, m.(Validator).Validate())
+	m.(clientMigration).setClient(cl)
+	assert.NoError(t, m.Migrate(context.Background(), db, map[string]interface{}{"${newbiePassword}": "pw"}))
+	assert.Equal(t, []string{
+		`PUT /_api/user/existing/database/Shop {"grant":"rw"}`,
+		`POST /_api/user {"passwd":"pw","active":true,"user":"newbie"}`,
+		`PUT /_api/user/newbie/database/Shop {"grant":"rw"}`,
+		`PUT /_api/user/intern/database/Shop {"grant":"none"}`,
+	}, *changes)
+
+	empty := parseMigration(t, "type: database\naction: modify\nname: Shop\n")
+	assert.EqualError(t, empty.(Validator).Validate(), "Database 'Shop' modify needs users to allow or disallow")
+}
+
+func TestMigrateInOtherDatabase(t *testing.T) {
+	ts, changes := recordingArango(t, map[string]reply{
+		"GET /_db/Shop/_api/database/current":         okReply(`{"result":{"name":"Shop"}}`),
+		"GET /_db/Shop/_api/collection/arangomigo":    okReply(`{"name":"arangomigo"}`),
+		"GET /_db/Reports/_api/database/current":      okReply(`{"result":{"name":"Reports"}}`),
+		"GET /_db/Reports/_api/collection/arangomigo": okReply(`{"name":"arangomigo"}`),
+		"POST /_db/Shop/_api/cursor":                  {status: http.StatusCreated, body: `{"result":[],"hasMore":false}`},
+		"POST /_db/Reports/_api/cursor":               {status: http.StatusCreated, body: `{"result":[],"hasMore":false}`},
+		"POST /_db/Shop/_api/document/arangomigo":     {status: http.StatusAccepted, body: `{"_key":"1.migration"}`},
+		"POST /_db/Reports/_api/document/arangomigo":  {status: http.StatusAccepted, body: `{"_key":"2.migration"}`},
+	})
+	defer ts.Close()
+	cl, err := client(Config{Endpoints: []string{ts.URL}})
+	assert.NoError(t, err)
+	db, err := cl.Database(context.Background(), "Shop")
+	assert.NoError(t, err)
+
+	pms := migrationsOf(t,
+		"type: aql\nname: here\nquery: RETURN 1\n",
+		"type: aql\nname: there\ndatabase: Reports\nquery: RETURN 2\n",
+	)
+	ran, err := migrateNow(context.Background(), Config{}, cl, db, pms)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, ran)
+	assert.Equal(t, []string{
+		`POST /_db/Shop/_api/cursor {"query":"RETURN 1","options":{"optimizer":{}}}`,
+		`POST /_db/Shop/_api/document/arangomigo {"_key":"1_step.migration","Checksum":""}`,
+		`POST /_db/Reports/_api/cursor {"query":"RETURN 2","options":{"optimizer":{}}}`,
+		`POST /_db/Reports/_api/document/arangomigo {"_key":"2_step.migration","Checksum":""}`,
+	}, *changes)
+}
+
+func TestPersistentIndexOptions(t *testing.T) {
+	ts, changes := recordingArango(t, map[string]reply{
+		"GET /_db/Shop/_api/database/current":    okReply(`{"result":{"name":"Shop"}}`),
+		"GET /_db/Shop/_api/collection/products": okReply(`{"name":"products"}`),
+		"POST /_db/Shop/_api/index":              {status: http.StatusCreated, body: `{"id":"products/1","type":"persistent"}`},
+	})
+	defer ts.Close()
+	cl, err := client(Config{Endpoints: []string{ts.URL}})
+	assert.NoError(t, err)
+	db, err := cl.Database(context.Background(), "Shop")
+	assert.NoError(t, err)
+
+	for _, contents := range []string{
+		"type: persistentindex\naction: create\nname: bySku\ncollection: products\nfields:\n  - sku\n" +
+			"storedvalues:\n  - price\ndeduplicate: false\nestimates: false\ncacheEnabled: true\n",
+		"type: geoindex\naction: create\nname: byArea\ncollection: products\nfields:\n  - area\n" +
+			"geojson: true\nlegacyPolygons: true\n",
+	} {
+		m := parseMigration(t, contents)
+		assert.NoError(t, m.(Validator).Validate())
+		assert.NoError(t, m.Migrate(context.Background(), db, nil))
+	}
+	assert.Equal(t, []string{
+		`POST /_db/Shop/_api/index {"type":"persistent","fields":["sku"],"unique":false,"deduplicate":false,` +
+			`"sparse":false,"inBackground":false,"estimates":false,"expireAfter":0,"name":"bySku","cacheEnabled":true,` +
+			`"storedValues":["price"],"error":false,"code":0,"errorNum":0,"errorMessage":""}`,
+		`POST /_db/Shop/_api/index {"type":"geo","fields":["area"],"geoJson":true,"inBackground":false,"expireAfter":0,` +
+			`"name":"byArea","legacyPolygons":true,"error":false,"code":0,"errorNum":0,"errorMessage":""}`,
+	}, *changes)
+}
+
+func TestIndexOptionValidation(t *testing.T) {
+	cases := map[string]string{
+		"type: persistentindex\nname: i\nfields:\n  - a\nstoredvalues:\n  - a\n":        "Persistent index 'i' already indexes 'a', so it can't store it too",
+		"type: persistentindex\nname: i\nfields:\n  - a\nstoredvalues:\n  - b\n  - b\n": "Persistent index 'i' stores 'b' twice",
+		"type: persistentindex\nname: i\nfields:\n  - a\nstoredvalues:\n  - _id\n":      "Persistent index 'i' can't store _id",
+		"type: geoindex\nname: g\nfields:\n  - a\nlegacyPolygons: false\n":              "Geo index 'g' only takes legacyPolygons with geojson",
+	}
+	for contents, want := range cases {
+		m := parseMigration(t, contents+"action: create\ncollection: c\n")
+		assert.EqualError(t, m.(Validator).Validate(), want)
+	}
+
+	m := parseMigration(t, "type: persistentindex\naction: create\nname: i\ncollection: c\nfields:\n  - a\n"+
+		"storedvalues:\n  - b\nestimates: true\n")
+	assert.Equal(t, []Requirements{{Server: ">=3.8"}, {Server: ">=3.10"}}, defaultRequirements(m))
+	assert.Empty(t, defaultRequirements(parseMigration(t, "type: persistentindex\naction: create\nfields:\n  - a\n")))
+}
+
+func TestIndexModify(t *testing.T) {
+	routes := map[string]reply{
+		"GET /_db/Shop/_api/database/current":    okReply(`{"result":{"name":"Shop"}}`),
+		"GET /_db/Shop/_api/collection/products": okReply(`{"name":"products"}`),
+		"GET /_db/Shop/_api/index": okReply(`{"indexes":[
+			{"id":"products/0","type":"primary","fields":["_key"]},
+			{"id":"products/6","name":"bySku_v2","type":"persistent","fields":["sku","color"]},
+			{"id":"products/7","name":"expiry","type":"ttl","fields":["at"]}]}`),
+		"POST /_db/Shop/_api/index":              {status: http.StatusCreated, body: `{"id":"products/8","type":"persistent"}`},
+		"DELETE /_db/Shop/_api/index/products/5": okReply(`{}`),
+		"DELETE /_db/Shop/_api/index/products/6": okReply(`{}`),
+		"DELETE /_db/Shop/_api/index/products/7": okReply(`{}`),
+	}
+	ts, changes := recordingArango(t, routes)
+	defer ts.Close()
+	cl, err := client(Config{Endpoints: []string{ts.URL}})
+	assert.NoError(t, err)
+	db, err := cl.Database(context.Background(), "Shop")
+	assert.NoError(t, err)
+	ctx := withIndexWait(context.Background(), Config{}, cl.Connection())
+
+	m := parseMigration(t, "type: persistentindex\naction: modify\nname: bySku\ncollection: products\n"+
+		"fields:\n  - sku\n  - size\nunique: true\n")
+	assert.NoError(t, m.Migrate(ctx, db, nil))
+	if assert.Len(t, *changes, 2) {
+		assert.Contains(t, (*changes)[0], `POST /_db/Shop/_api/index {"type":"persistent","fields":["sku","size"],"unique":true,`)
+		assert.Contains(t, (*changes)[0], `"inBackground":true,`)
+		assert.Contains(t, (*changes)[0], `"name":"bySku_v3"`)
+		assert.Equal(t, "DELETE /_db/Shop/_api/index/products/6", (*changes)[1])
+	}
+
+	// A collection has one TTL index, so it's dropped and built again.
+	*changes = nil
+	m = parseMigration(t, "type: ttlindex\naction: modify\nname: expiry\ncollection: products\nfield: at\nexpireafter: 60\n")
+	assert.NoError(t, m.Migrate(ctx, db, nil))
+	if assert.Len(t, *changes, 2) {
+		assert.Equal(t, "DELETE /_db/Shop/_api/index/products/7", (*changes)[0])
+		assert.Contains(t, (*changes)[1], `"name":"expiry"`)
+	}
+
+	// Arango returns the index that already has the definition, so nothing
+	// is dropped.
+	*changes = nil
+	routes["POST /_db/Shop/_api/index"] = okReply(`{"id":"products/6","name":"bySku_v2","type":"persistent"}`)
+	m = parseMigration(t, "type: persistentindex\naction: modify\nname: bySku\ncollection: products\n"+
+		"fields:\n  - sku\n  - color\n")
+	assert.NoError(t, m.Migrate(ctx, db, nil))
+	assert.Len(t, *changes, 1)
+
+	// When it's another index that has it, the old one isn't modified.
+	*changes = nil
+	routes["POST /_db/Shop/_api/index"] = okReply(`{"id":"products/9","name":"bySkuAndColor","type":"persistent"}`)
+	assert.EqualError(
+		t,
+		m.Migrate(ctx, db, nil),
+		"Index 'bySkuAndColor' on collection products already has the new definition, "+
+			"so 'bySku_v3' wasn't built and 'bySku_v2' keeps the old one",
+	)
+	assert.Len(t, *changes, 1)
+
+	// Deleting follows the index to its latest version.
+	*changes = nil
+	m = parseMigration(t, "type: persistentindex\naction: delete\nname: bySku\ncollection: products\n")
+	assert.NoError(t, m.Migrate(ctx, db, nil))
+	assert.Equal(t, []string{"DELETE /_db/Shop/_api/index/products/6"}, *changes)
+
+	// But only once the index with the name itself is gone.
+	*changes = nil
+	routes["GET /_db/Shop/_api/index"] = okReply(`{"indexes":[
+		{"id":"products/5","name":"bySku","type":"persistent","fields":["sku"]},
+		{"id":"products/6","name":"bySku_v2","type":"persistent","fields":["sku","color"]}]}`)
+	assert.NoError(t, m.Migrate(ctx, db, nil))
+	assert.Equal(t, []string{"DELETE /_db/Shop/_api/index/products/5"}, *changes)
+}
+
+func TestIndexModifyWaitsForTheNewVersion(t *testing.T) {
+	var polls, posted int32
+	routes := map[string]reply{
+		"GET /_db/Shop/_api/database/current":    okReply(`{"result":{"name":"Shop"}}`),
+		"GET /_db/Shop/_api/collection/products": okReply(`{"name":"products"}`),
+		"POST /_db/Shop/_api/index":              {status: http.StatusCreated, body: `{"id":"products/8","name":"bySku_v2","type":"persistent"}`},
+		"DELETE /_db/Shop/_api/index/products/5": okReply(`{}`),
+	}
+	var changes []string
+	handler := arangoHandler(routes)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/_db/Shop/_api/index" && r.Method == http.MethodGet {
+			w.Header().Set("Content-Type", "application/json")
+			indexes := `{"id":"products/5","name":"bySku","type":"persistent","fields":["sku"]}`
+			if atomic.LoadInt32(&posted) == 1 {
+				building := atomic.AddInt32(&polls, 1) < 3
+				indexes += fmt.Sprintf(`,{"id":"products/8","name":"bySku_v2","type":"persistent",`+
+					`"fields":["sku","size"],"isBuilding":%t,"progress":50}`, building)
+			}
+			w.Write([]byte(`{"indexes":[` + indexes + `]}`))
+			return
+		}
+		if r.Method == http.MethodPost {
+			atomic.StoreInt32(&posted, 1)
+		}
+		if r.Method != http.MethodGet {
+			changes = append(changes, r.Method+" "+r.URL.Path)
+		}
+		handler(w, r)
+	}))
+	defer ts.Close()
+	c := Config{Endpoints: []string{ts.URL}, IndexWaitInterval: time.Millisecond}
+	cl, err := client(c)
+	assert.NoError(t, err)
+	db, err := cl.Database(context.Background(), "Shop")
+	assert.NoError(t, err)
+
+	// index_wait isn't set, modify waits anyway.
+	m := parseMigration(t, "type: persistentindex\naction: modify\nname: bySku\ncollection: products\n"+
+		"fields:\n  - sku\n  - size\n")
+	assert.NoError(t, m.Migrate(withIndexWait(context.Background(), c, cl.Connection()), db, nil))
+	assert.Equal(t, int32(3), atomic.LoadInt32(&polls))
+	assert.Equal(t, []string{"POST /_db/Shop/_api/index", "DELETE /_db/Shop/_api/index/products/5"}, changes)
+}
+
+func TestIndexExistingUnderAnotherName(t *testing.T) {
+	routes := map[string]reply{
+		"GET /_db/Shop/_api/database/current":       okReply(`{"result":{"name":"Shop"}}`),
+		"GET /_db/Shop/_api/collection/products":    okReply(`{"name":"products"}`),
+		"POST /_db/Shop/_api/index":                 okReply(`{"id":"products/5","name":"idx_173","type":"hash","fields":["sku"]}`),
+		"GET /_db/Shop/_api/index/products/idx_173": okReply(`{"id":"products/5","name":"idx_173","type":"hash","fields":["sku"]}`),
+		"DELETE /_db/Shop/_api/index/products/5":    okReply(`{}`),
+	}
+	ts, changes := recordingArango(t, routes)
+	defer ts.Close()
+	cl, err := client(Config{Endpoints: []string{ts.URL}})
+	assert.NoError(t, err)
+	db, err := cl.Database(context.Background(), "Shop")
+	assert.NoError(t, err)
+	hash := "type: hashindex\naction: create\nname: bySku\ncollection: products\nfields:\n  - sku\n"
+
+	assert.NoError(t, parseMigration(t, hash).Migrate(context.Background(), db, nil))
+	assert.Len(t, *changes, 1)
+
+	m := parseMigration(t, hash+"existing: fail\n")
+	assert.NoError(t, m.(Validator).Validate())
+	assert.EqualError(
+		t,
+		m.Migrate(context.Background(), db, nil),
+		"Index 'bySku' already existed as 'idx_173' on collection products, with the same definition",
+	)
+
+	// When Arango builds the index under its name next to the other one,
+	// the other one goes afterwards.
+	*changes = nil
+	m = parseMigration(t, hash+"existing: adopt\n")
+	posts := 0
+	ts.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		*changes = append(*changes, r.Method+" "+r.URL.Path)
+		if r.Method == http.MethodPost {
+			if posts++; posts == 2 {
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusCreated)
+				w.Write([]byte(`{"id":"products/9","name":"bySku","type":"hash","fields":["sku"]}`))
+				return
+			}
+		}
+		arangoHandler(routes)(w, r)
+	})
+	assert.NoError(t, m.Migrate(context.Background(), db, nil))
+	assert.Equal(t, []string{
+		"GET /_db/Shop/_api/collection/products",
+		"POST /_db/Shop/_api/index",
+		"GET /_db/Shop/_api/index/products/idx_173",
+		"GET /_db/Shop/_api/collection/products",
+		"POST /_db/Shop/_api/index",
+		"DELETE /_db/Shop/_api/index/products/5",
+	}, *changes)
+
+	// Otherwise it's dropped first, and Arango builds the index under its name.
+	*changes = nil
+	var built bool
+	ts.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodDelete {
+			built = true
+			routes["POST /_db/Shop/_api/index"] = reply{
+				status: http.StatusCreated,
+				body:   `{"id":"products/9","name":"bySku","type":"hash","fields":["sku"]}`,
+			}
+		}
+		*changes = append(*changes, r.Method+" "+r.URL.Path)
+		arangoHandler(routes)(w, r)
+	})
+	assert.NoError(t, m.Migrate(context.Background(), db, nil))
+	assert.True(t, built)
+	assert.Equal(t, []string{
+		"GET /_db/Shop/_api/collection/products",
+		"POST /_db/Shop/_api/index",
+		"GET /_db/Shop/_api/index/products/idx_173",
+		"GET /_db/Shop/_api/collection/products",
+		"POST /_db/Shop/_api/index",
+		"DELETE /_db/Shop/_api/index/products/5",
+		"GET /_db/Shop/_api/collection/products",
+		"POST /_db/Shop/_api/index",
+	}, *changes)
+
+	// If Arango won't build it under the new name, it gets its old one back.
+	*changes = nil
+	built = false
+	routes["POST /_db/Shop/_api/index"] = okReply(`{"id":"products/5","name":"idx_173","type":"hash","fields":["sku"]}`)
+	ts.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			var body map[string]interface{}
+			assert.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+			*changes = append(*changes, fmt.Sprintf("POST %v", body["name"]))
+			if body["name"] == "bySku" && built {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				w.Write([]byte(`{"error":true,"code":503,"errorNum":1,"errorMessage":"unavailable"}`))
+				return
+			}
+		} else {
+			*changes = append(*changes, r.Method+" "+r.URL.Path)
+		}
+		if r.Method == http.MethodDelete {
+			built = true
+		}
+		arangoHandler(routes)(w, r)
+	})
+	err = m.Migrate(context.Background(), db, nil)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "Couldn't adopt index 'idx_173' on collection products as 'bySku', "+
+			"so it was built again as 'idx_173'")
+	}
+	assert.Equal(t, []string{
+		"GET /_db/Shop/_api/collection/products",
+		"POST bySku",
+		"GET /_db/Shop/_api/index/products/idx_173",
+		"GET /_db/Shop/_api/collection/products",
+		"POST bySku",
+		"DELETE /_db/Shop/_api/index/products/5",
+		"GET /_db/Shop/_api/collection/products",
+		"POST bySku",
+		"GET /_db/Shop/_api/collection/products",
+		"POST idx_173",
+	}, *changes)
+
+	// A unique index is never dropped to adopt it, duplicates could get in.
+	*changes = nil
+	routes["GET /_db/Shop/_api/index/products/idx_173"] = okReply(
+		`{"id":"products/5","name":"idx_173","type":"hash","fields":["sku"],"unique":true}`)
+	ts.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		*changes = append(*changes, r.Method+" "+r.URL.Path)
+		arangoHandler(routes)(w, r)
+	})
+	m = parseMigration(t, hash+"unique: true\nexisting: adopt\n")
+	assert.EqualError(
+		t,
+		m.Migrate(context.Background(), db, nil),
+		"Index 'idx_173' on collection products is unique and Arango won't build a copy of it as 'bySku', so it "+
+			"can't be adopted without dropping the constraint while it's rebuilt. It keeps its name",
+	)
+	assert.NotContains(t, *changes, "DELETE /_db/Shop/_api/index/products/5")
+
+	m = parseMigration(t, hash+"existing: ignore\n")
+	assert.EqualError(t, m.(Validator).Validate(), "Index 'bySku' has unknown existing 'ignore', use warn, fail or adopt")
+}
@@ -3,15 +3,20 @@ package arangomigo
 import (
 	"context"
 	"crypto/md5"
-	"crypto/tls"
 	"encoding/hex"
 	"fmt"
 	"log"
+	"net/url"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/pkg/errors"
 
 	"github.com/arangodb/go-driver"
-	"github.com/arangodb/go-driver/http"
 )
 
 const (
@@ -47,6 +52,12 @@ func (op *Operation) SetCheckSum(sum string) {
 	op.checksum = sum
 }
 
+// TargetDatabase gets the database the migration runs in, or an empty string
+// for the one in the config.
+func (op *Operation) TargetDatabase() string {
+	return op.Database
+}
+
 // End Common operation implementations
 
 func PerformMigrations(ctx context.Context, c Config, ms []Migration) error {
@@ -64,11 +75,40 @@ func PerformMigrations(ctx context.Context, c Config, ms []Migration) error {
 // Entry point in actually executing the migrations
 func perform(ctx context.Context, c Config, pm []PairedMigrations) error {
 	cl, err := client(c)
+	if e(err) {
+		return err
+	}
+	if err := waitForArango(ctx, c, cl); e(err) {
+		return err
+	}
+	for _, p := range pm {
+		for _, step := range []Migration{p.change, p.undo} {
+			if cm, ok := step.(clientMigration); ok {
+				cm.setClient(cl)
+			}
+		}
+	}
+	if c.multiTenant() {
+		return performTenants(ctx, c, cl, pm)
+	}
+	if err := preflight(ctx, c, cl, pm); e(err) {
+		return err
+	}
+	ran, err := applied(ctx, cl, c.Db, pm)
+	if e(err) {
+		return err
+	}
+	if err := validate(pm, ran); e(err) {
+		return err
+	}
+	if err := checkRequirements(ctx, cl, pending(pm, ran)); e(err) {
+		return err
+	}
 	db, err := loadDb(ctx, c, cl, &pm, c.Extras)
 	if e(err) {
 		return err
 	}
-	err = migrateNow(ctx, db, pm, c.Extras)
+	_, err = migrateNow(ctx, c, cl, db, pm)
 	return err
 }
 
@@ -78,50 +118,128 @@ type migration struct {
 	Checksum string
 }
 
+// clientMigration is implemented by migrations that work on the server
+// rather than inside the database, such as managing users.
+type clientMigration interface {
+	setClient(cl driver.Client)
+}
+
+// migrateNow runs the migrations that haven't run yet, returning how many did.
 func migrateNow(
 	ctx context.Context,
+	c Config,
+	cl driver.Client,
 	db driver.Database,
 	pms []PairedMigrations,
-	extras map[string]interface{},
-) error {
-	log.Println("Starting migration now")
+) (int, error) {
+	log.Printf("Starting migration of %s now\n", db.Name())
+	ctx = withIndexWait(ctx, c, cl.Connection())
+	extras := c.Extras
+	ran := 0
+	ranNow := map[string]bool{}
 
 	mcol, err := db.Collection(ctx, migCol)
 	if e(err) {
-		return err
+		return ran, err
+	}
+	targets := databases{
+		cl:        cl,
+		dbs:       map[string]driver.Database{db.Name(): db},
+		histories: map[string]driver.Collection{db.Name(): mcol},
 	}
 
 	for _, pm := range pms {
 		m := pm.change
 		u := pm.undo
 
+		db, mcol := db, mcol
+		if t, ok := m.(targeted); ok && t.TargetDatabase() != "" {
+			db, mcol, err = targets.open(ctx, t.TargetDatabase())
+			if e(err) {
+				return ran, errors.Wrapf(err, "Couldn't open database '%s' for %s", t.TargetDatabase(), m.FileName())
+			}
+		}
+
 		// Since migrations are stored by their file names, just see if it exists
 		migRan, err := mcol.DocumentExists(ctx, m.FileName())
 		if e(err) {
-			return err
+			return ran, err
 		}
 
-		if !migRan {
-			err := m.Migrate(ctx, db, extras)
+		if migRan {
+			missedConversion(db.Name(), m, ranNow)
+		} else {
+			err := migrateWithin(ctx, c.MigrationTimeout, func(ctx context.Context) error {
+				return m.Migrate(ctx, db, extras)
+			})
 			if !e(err) {
+				ran++
+				ranNow[m.FileName()] = true
 				if temp, ok := m.(*Database); !ok || temp.Action == MODIFY {
 					_, err := mcol.CreateDocument(ctx, &migration{Key: m.FileName(), Checksum: m.CheckSum()})
 					if e(err) {
-						return err
+						return ran, err
 					}
 				}
 			} else if e(err) && driver.IsArangoError(err) && u != nil {
 				// This probably means a migration issue, back out.
 				err = u.Migrate(ctx, db, extras)
 				if e(err) {
-					return err
+					return ran, err
 				}
 			} else {
-				return err
+				return ran, err
 			}
 		}
 	}
-	return nil
+	return ran, nil
+}
+
+// targeted is implemented by migrations that can run in another database.
+type targeted interface {
+	TargetDatabase() string
+}
+
+// databases holds the databases the migrations run in, each keeping its own
+// history, all over the same connection.
+type databases struct {
+	cl        driver.Client
+	dbs       map[string]driver.Database
+	histories map[string]driver.Collection
+}
+
+func (d databases) open(ctx context.Context, name string) (driver.Database, driver.Collection, error) {
+	if db, ok := d.dbs[name]; ok {
+		return db, d.histories[name], nil
+	}
+	db, err := d.cl.Database(ctx, name)
+	if e(err) {
+		return nil, nil, err
+	}
+	if err := ensureHistory(ctx, db); e(err) {
+		return nil, nil, err
+	}
+	mcol, err := db.Collection(ctx, migCol)
+	if e(err) {
+		return nil, nil, err
+	}
+	d.dbs[name] = db
+	d.histories[name] = mcol
+	return db, mcol, nil
+}
+
+// migrateWithin runs the step with a deadline when a timeout is configured.
+func migrateWithin(ctx context.Context, timeout time.Duration, step func(ctx context.Context) error) error {
+	if timeout <= 0 {
+		return step(ctx)
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	err := step(ctx)
+	if e(err) && ctx.Err() == context.DeadlineExceeded {
+		return errors.Wrapf(err, "Migration exceeded the timeout of %s", timeout)
+	}
+	return err
 }
 
 func pointyBool(bool2 bool) *bool {
@@ -141,7 +259,7 @@ func loadDb(
 		// Creating a database requires extra setup.
 		m := (*pm)[0].change
 		o, ok := m.(*Database)
-		if !ok {
+		if !ok || o.Action != CREATE {
 			return nil, errors.Errorf("Database %s does not exist and first migration is not the DB creation", dbName)
 		}
 		if o.Name != dbName {
@@ -155,54 +273,109 @@ func loadDb(
 		}
 	} else if err == nil {
 		m := (*pm)[0].change
-		switch m.(type) {
-		case *Database:
+		if o, ok := m.(*Database); ok && o.Action == CREATE {
 			*pm = (*pm)[1:]
 		}
 	}
 
 	if err == nil {
-		// Check to see if the migration coll is there.
-		_, err := db.Collection(ctx, migCol)
-		if driver.IsNotFoundGeneral(err) {
-			ko := driver.CollectionKeyOptions{}
-			ko.AllowUserKeysPtr = pointyBool(true)
-			options := driver.CreateCollectionOptions{}
-			options.KeyOptions = &ko
-			if _, err := db.CreateCollection(ctx, migCol, &options); err != nil {
-				log.Printf("Failed to create collection %s", migCol)
-				return db, err
-			}
-		}
+		err = ensureHistory(ctx, db)
 	}
 
 	return db, err
 }
 
-// Create the client used to talk to ArangoDB
-func client(c Config) (driver.Client, error) {
-	conn, err := http.NewConnection(http.ConnectionConfig{
-		Endpoints: c.Endpoints,
-		TLSConfig: &tls.Config{
-			InsecureSkipVerify: c.SkipSslVerify,
-		},
-	})
+// applied finds the migrations the history already records, by file name.
+// Each is looked up in the history of the database it runs in. Nothing has
+// run in a database that doesn't exist yet, except creating it.
+func applied(ctx context.Context, cl driver.Client, dbName string, pms []PairedMigrations) (map[string]bool, error) {
+	ran := map[string]bool{}
+	histories := map[string]driver.Collection{}
+	history := func(name string) (driver.Collection, error) {
+		if mcol, ok := histories[name]; ok {
+			return mcol, nil
+		}
+		var mcol driver.Collection
+		db, err := cl.Database(ctx, name)
+		if err == nil {
+			mcol, err = db.Collection(ctx, migCol)
+		}
+		if driver.IsNotFoundGeneral(err) {
+			mcol, err = nil, nil
+		}
+		if e(err) {
+			return nil, errors.Wrapf(err, "Couldn't read the migration history of database '%s'", name)
+		}
+		histories[name] = mcol
+		return mcol, nil
+	}
 
-	if e(err) {
-		return nil, errors.New("Couldn't create connection to Arango\n" + err.Error())
+	for _, pm := range pms {
+		m := pm.change
+		target := dbName
+		if t, ok := m.(targeted); ok && t.TargetDatabase() != "" {
+			target = t.TargetDatabase()
+		}
+		if d, ok := m.(*Database); ok && d.Action == CREATE {
+			exists, err := cl.DatabaseExists(ctx, d.Name)
+			if e(err) {
+				return nil, errors.Wrapf(err, "Couldn't check if database '%s' exists", d.Name)
+			}
+			ran[m.FileName()] = exists
+			continue
+		}
+		mcol, err := history(target)
+		if e(err) {
+			return nil, err
+		}
+		if mcol == nil {
+			continue
+		}
+		exists, err := mcol.DocumentExists(ctx, m.FileName())
+		if e(err) {
+			return nil, errors.Wrapf(err, "Couldn't read the migration history of database '%s'", target)
+		}
+		ran[m.FileName()] = exists
 	}
-	cl, err := driver.NewClient(driver.ClientConfig{
-		Connection:     conn,
-		Authentication: driver.BasicAuthentication(c.Username, c.Password),
-	})
+	return ran, nil
+}
 
-	return cl, err
+// pending leaves out the migrations that already ran.
+func pending(pms []PairedMigrations, ran map[string]bool) []PairedMigrations {
+	var out []PairedMigrations
+	for _, pm := range pms {
+		if !ran[pm.change.FileName()] {
+			out = append(out, pm)
+		}
+	}
+	return out
+}
+
+// ensureHistory creates the collection recording which migrations ran.
+func ensureHistory(ctx context.Context, db driver.Database) error {
+	// Check to see if the migration coll is there.
+	_, err := db.Collection(ctx, migCol)
+	if driver.IsNotFoundGeneral(err) {
+		ko := driver.CollectionKeyOptions{}
+		ko.AllowUserKeysPtr = pointyBool(true)
+		options := driver.CreateCollectionOptions{}
+		options.KeyOptions = &ko
+		if _, err := db.CreateCollection(ctx, migCol, &options); err != nil {
+			log.Printf("Failed to create collection %s in %s", migCol, db.Name())
+			return err
+		}
+	}
+	return nil
 }
 
 func e(err error) bool {
 	return err != nil
 }
 
+func (d *Database) setClient(cl driver.Client) {
+	d.cl = cl
+}
+
 func (d *Database) Migrate(ctx context.Context, db driver.Database, extras map[string]interface{}) error {
 	var oerr error
 	switch d.Action {
@@ -211,6 +384,15 @@ func (d *Database) Migrate(ctx context.Context, db driver.Database, extras map[s
 			return nil
 		}
 		options := driver.CreateDatabaseOptions{}
+		if d.Sharding != nil {
+			options.Options.Sharding = driver.DatabaseSharding(*d.Sharding)
+		}
+		if d.ReplicationFactor != nil {
+			options.Options.ReplicationFactor = int(*d.ReplicationFactor)
+		}
+		if d.WriteConcern != nil {
+			options.Options.WriteConcern = *d.WriteConcern
+		}
 		active := true
 		for _, u := range d.Allowed {
 			options.Users = append(
@@ -225,9 +407,23 @@ func (d *Database) Migrate(ctx context.Context, db driver.Database, extras map[s
 		newdb, err := d.cl.CreateDatabase(ctx, d.Name, &options)
 		if err == nil {
 			d.db = newdb
+			oerr = d.disallow(ctx, newdb, extras)
 		} else {
 			oerr = err
 		}
+	case MODIFY:
+		target := db
+		if db == nil || db.Name() != d.Name {
+			other, err := d.cl.Database(ctx, d.Name)
+			if e(err) {
+				return errors.Wrapf(err, "Couldn't find database '%s' to modify", d.Name)
+			}
+			target = other
+		}
+		if err := d.allow(ctx, target, extras); e(err) {
+			return err
+		}
+		return d.disallow(ctx, target, extras)
 	case DELETE:
 		err := db.Remove(ctx)
 		if e(err) {
@@ -240,6 +436,111 @@ func (d *Database) Migrate(ctx context.Context, db driver.Database, extras map[s
 	return errors.Wrap(oerr, "Couldn't create database")
 }
 
+// allow gives the allowed users rw access to the database, creating the ones
+// that don't exist yet. Existing users keep their passwords.
+func (d *Database) allow(ctx context.Context, target driver.Database, extras map[string]interface{}) error {
+	for _, u := range d.Allowed {
+		name, ok := directReplace(u.Username, extras).(string)
+		if !ok {
+			return errors.Errorf("Usernames allowed in database '%s' must be strings", d.Name)
+		}
+		exists, err := d.cl.UserExists(ctx, name)
+		if e(err) {
+			return errors.Wrapf(err, "Couldn't check if user '%s' exists", name)
+		}
+		var user driver.User
+		if exists {
+			user, err = d.cl.User(ctx, name)
+		} else {
+			password, ok := directReplace(u.Password, extras).(string)
+			if !ok {
+				return errors.Errorf("The password of user '%s' must be a string", name)
+			}
+			user, err = d.cl.CreateUser(ctx, name, &driver.UserOptions{Password: password, Active: pointyBool(true)})
+			if !e(err) {
+				log.Printf("Created user '%s'\n", name)
+			}
+		}
+		if e(err) {
+			return errors.Wrapf(err, "Couldn't allow user '%s' in database '%s'", name, d.Name)
+		}
+		if err := user.SetDatabaseAccess(ctx, target, driver.GrantReadWrite); e(err) {
+			return errors.Wrapf(err, "Couldn't allow user '%s' in database '%s'", name, d.Name)
+		}
+		log.Printf("Allowed user '%s' in database '%s'\n", name, d.Name)
+	}
+	return nil
+}
+
+// disallow revokes the disallowed users' access to the database. It sets
+// none rather than removing the grant, so a server-wide default can't let
+// them back in.
+func (d *Database) disallow(ctx context.Context, target driver.Database, extras map[string]interface{}) error {
+	for _, username := range d.Disallowed {
+		name, ok := directReplace(username, extras).(string)
+		if !ok {
+			return errors.Errorf("Usernames disallowed in database '%s' must be strings", d.Name)
+		}
+		user, err := d.cl.User(ctx, name)
+		if driver.IsNotFoundGeneral(err) {
+			log.Printf("User '%s' doesn't exist, so has no access to database '%s'\n", name, d.Name)
+			continue
+		} else if e(err) {
+			return errors.Wrapf(err, "Couldn't find user '%s' to disallow", name)
+		}
+		if err := user.SetDatabaseAccess(ctx, target, driver.GrantNone); e(err) {
+			return errors.Wrapf(err, "Couldn't disallow user '%s' in database '%s'", name, d.Name)
+		}
+		log.Printf("Disallowed user '%s' in database '%s'\n", name, d.Name)
+	}
+	return nil
+}
+
+var databaseShardings = map[string]bool{
+	string(driver.DatabaseShardingSingle): true,
+	"flexible":                            true,
+}
+
+// Validate checks the database's users and the defaults it gives new collections.
+func (d Database) Validate() error {
+	if d.Database != "" {
+		return errors.Errorf("Database '%s' can't set database, the name is the database it works on", d.Name)
+	}
+	configures := d.Sharding != nil || d.ReplicationFactor != nil || d.WriteConcern != nil
+	if configures && d.Action != CREATE {
+		return errors.Errorf(
+			"Database '%s' can only set sharding, replicationfactor and writeconcern when it's created",
+			d.Name,
+		)
+	}
+	if d.Action == MODIFY && len(d.Allowed) == 0 && len(d.Disallowed) == 0 {
+		return errors.Errorf("Database '%s' modify needs users to allow or disallow", d.Name)
+	}
+	for _, u := range d.Allowed {
+		if u.Username == "" {
+			return errors.Errorf("Database '%s' allows a user without a username", d.Name)
+		}
+	}
+	if d.Sharding != nil && !databaseShardings[*d.Sharding] {
+		return errors.Errorf("Database '%s' has unknown sharding '%s', use single or flexible", d.Name, *d.Sharding)
+	}
+	if d.ReplicationFactor != nil && (d.ReplicationFactor.Satellite() || *d.ReplicationFactor < 1) {
+		return errors.Errorf("Database '%s' needs a replicationfactor of at least 1", d.Name)
+	}
+	if d.WriteConcern != nil {
+		if *d.WriteConcern < 1 {
+			return errors.Errorf("Database '%s' needs a writeconcern of at least 1", d.Name)
+		}
+		if d.ReplicationFactor != nil && *d.WriteConcern > int(*d.ReplicationFactor) {
+			return errors.Errorf(
+				"Database '%s' has a writeconcern of %d, more than its replicationfactor of %d",
+				d.Name, *d.WriteConcern, *d.ReplicationFactor,
+			)
+		}
+	}
+	return nil
+}
+
 // directReplace attempts to use the key value to find a lookup in the map.
 // if one exists, it returns the values; otherwise returns the key.
 func directReplace(key string, extras map[string]interface{}) interface{} {
@@ -249,6 +550,337 @@ func directReplace(key string, extras map[string]interface{}) interface{} {
 	return key
 }
 
+var shardingStrategies = map[string]bool{
+	string(driver.ShardingStrategyCommunityCompat):           true,
+	string(driver.ShardingStrategyEnterpriseCompat):          true,
+	string(driver.ShardingStrategyEnterpriseSmartEdgeCompat): true,
+	string(driver.ShardingStrategyHash):                      true,
+	string(driver.ShardingStrategyEnterpriseHashSmartEdge):   true,
+}
+
+// Validate checks the cluster options make sense together.
+func (cl Collection) Validate() error {
+	switch cl.Action {
+	case CREATE:
+		return cl.validateCreate()
+	case MODIFY:
+		return cl.validateModify()
+	case RENAME:
+		if cl.NewName == "" {
+			return errors.Errorf("Collection '%s' needs a newName to rename to", cl.Name)
+		}
+		if cl.NewName == cl.Name {
+			return errors.Errorf("Collection '%s' already has the newName '%s'", cl.Name, cl.NewName)
+		}
+	}
+	if cl.NewName != "" && cl.Action != RENAME {
+		return errors.Errorf("Collection '%s' only takes a newName when the action is rename", cl.Name)
+	}
+	return nil
+}
+
+func (cl Collection) validateCreate() error {
+	if cl.NumberOfShards != nil && *cl.NumberOfShards < 1 {
+		return errors.Errorf("Collection '%s' needs at least 1 shard", cl.Name)
+	}
+	if err := cl.validateKeyGenerator(); e(err) {
+		return err
+	}
+	if cl.ReplicationFactor != nil {
+		rf := *cl.ReplicationFactor
+		if rf.Satellite() && cl.NumberOfShards != nil && *cl.NumberOfShards != 1 {
+			return errors.Errorf("Satellite collection '%s' always has 1 shard", cl.Name)
+		}
+		if rf.Satellite() && cl.WriteConcern != nil {
+			return errors.Errorf("Satellite collection '%s' can't set a writeconcern", cl.Name)
+		}
+	}
+	if err := cl.validateReplication(); e(err) {
+		return err
+	}
+	if cl.DistributeShardsLike != nil && (cl.NumberOfShards != nil || cl.ReplicationFactor != nil) {
+		return errors.Errorf(
+			"Collection '%s' takes its shards from '%s', so it can't set numberofshards or replicationfactor",
+			cl.Name, *cl.DistributeShardsLike,
+		)
+	}
+	if cl.ShardingStrategy != nil && !shardingStrategies[*cl.ShardingStrategy] {
+		return errors.Errorf("Collection '%s' has unknown shardingstrategy '%s'", cl.Name, *cl.ShardingStrategy)
+	}
+	if err := cl.validateSchema(); e(err) {
+		return err
+	}
+	if err := cl.validateComputedValues(); e(err) {
+		return err
+	}
+	if cl.SmartJoinAttribute != nil {
+		if cl.ShardKeys == nil || len(*cl.ShardKeys) != 1 || !strings.HasSuffix((*cl.ShardKeys)[0], ":") {
+			return errors.Errorf(
+				"Collection '%s' uses smartjoinattribute, so it needs a single shard key ending in ':', such as '_key:'",
+				cl.Name,
+			)
+		}
+	}
+	return nil
+}
+
+// validateModify rejects the properties Arango fixes when the collection is
+// created. Changing them means creating a new collection.
+func (cl Collection) validateModify() error {
+	var fixed []string
+	for name, set := range map[string]bool{
+		"shardkeys":            cl.ShardKeys != nil,
+		"numberofshards":       cl.NumberOfShards != nil,
+		"allowuserkeys":        cl.AllowUserKeys != nil,
+		"keygeneratortype":     cl.KeyGeneratorType != nil,
+		"increment":            cl.Increment != nil,
+		"offset":               cl.Offset != nil,
+		"volatile":             cl.Volatile != nil,
+		"compactable":          cl.Compactable != nil,
+		"collectiontype":       cl.CollectionType != "",
+		"distributeshardslike": cl.DistributeShardsLike != nil,
+		"shardingstrategy":     cl.ShardingStrategy != nil,
+		"smartjoinattribute":   cl.SmartJoinAttribute != nil,
+	} {
+		if set {
+			fixed = append(fixed, name)
+		}
+	}
+	if len(fixed) > 0 {
+		sort.Strings(fixed)
+		return errors.Errorf(
+			"Collection '%s' can't change %s after it's created",
+			cl.Name, strings.Join(fixed, ", "),
+		)
+	}
+	if cl.ReplicationFactor != nil && cl.ReplicationFactor.Satellite() {
+		return errors.Errorf("Collection '%s' can't become a satellite after it's created", cl.Name)
+	}
+	if err := cl.validateReplication(); e(err) {
+		return err
+	}
+	if err := cl.validateSchema(); e(err) {
+		return err
+	}
+	return cl.validateComputedValues()
+}
+
+var keyGenerators = map[string]bool{
+	string(driver.KeyGeneratorTraditional):   true,
+	string(driver.KeyGeneratorAutoIncrement): true,
+	"uuid":                                   true,
+	"padded":                                 true,
+}
+
+func (cl Collection) validateKeyGenerator() error {
+	if cl.KeyGeneratorType != nil && !keyGenerators[*cl.KeyGeneratorType] {
+		return errors.Errorf(
+			"Collection '%s' has unknown keygeneratortype '%s', use traditional, autoincrement, uuid or padded",
+			cl.Name, *cl.KeyGeneratorType,
+		)
+	}
+	if (cl.Increment != nil || cl.Offset != nil) && !cl.autoincrement() {
+		return errors.Errorf(
+			"Collection '%s' sets increment or offset, which only the autoincrement keygeneratortype uses",
+			cl.Name,
+		)
+	}
+	if cl.Increment != nil && (*cl.Increment < 1 || *cl.Increment > 65535) {
+		return errors.Errorf("Collection '%s' needs an increment between 1 and 65535", cl.Name)
+	}
+	if cl.Offset != nil && *cl.Offset < 0 {
+		return errors.Errorf("Collection '%s' can't have a negative offset", cl.Name)
+	}
+	if cl.autoincrement() && cl.NumberOfShards != nil && *cl.NumberOfShards > 1 {
+		return errors.Errorf("Collection '%s' uses autoincrement keys, so it can only have 1 shard", cl.Name)
+	}
+	return nil
+}
+
+// autoincrement reports whether the collection numbers its keys in order,
+// which Arango only supports on a single server.
+func (cl Collection) autoincrement() bool {
+	return cl.KeyGeneratorType != nil && *cl.KeyGeneratorType == string(driver.KeyGeneratorAutoIncrement)
+}
+
+func (cl Collection) validateReplication() error {
+	if cl.ReplicationFactor != nil {
+		rf := *cl.ReplicationFactor
+		if !rf.Satellite() && rf < 1 {
+			return errors.Errorf("Collection '%s' needs a replicationfactor of at least 1 or satellite", cl.Name)
+		}
+	}
+	if cl.WriteConcern != nil {
+		if *cl.WriteConcern < 1 {
+			return errors.Errorf("Collection '%s' needs a writeconcern of at least 1", cl.Name)
+		}
+		if cl.ReplicationFactor != nil && *cl.WriteConcern > int(*cl.ReplicationFactor) {
+			return errors.Errorf(
+				"Collection '%s' has a writeconcern of %d, more than its replicationfactor of %d",
+				cl.Name, *cl.WriteConcern, *cl.ReplicationFactor,
+			)
+		}
+	}
+	return nil
+}
+
+var schemaLevels = map[string]bool{
+	string(driver.CollectionSchemaLevelNone):     true,
+	string(driver.CollectionSchemaLevelNew):      true,
+	string(driver.CollectionSchemaLevelModerate): true,
+	string(driver.CollectionSchemaLevelStrict):   true,
+}
+
+func (cl Collection) validateSchema() error {
+	if cl.Schema == nil {
+		return nil
+	}
+	s := cl.Schema
+	if s.Rule != nil && s.RuleFile != "" {
+		return errors.Errorf("Collection '%s' schema has both a rule and a rulefile, pick one", cl.Name)
+	}
+	if s.RuleFile != "" && !strings.EqualFold(filepath.Ext(s.RuleFile), ".json") {
+		return errors.Errorf("Collection '%s' schema rulefile '%s' must be a .json file", cl.Name, s.RuleFile)
+	}
+	if s.Rule == nil && s.RuleFile == "" {
+		return errors.Errorf("Collection '%s' schema needs a rule or rulefile", cl.Name)
+	}
+	if s.Level != "" && !schemaLevels[s.Level] {
+		return errors.Errorf(
+			"Collection '%s' schema has unknown level '%s', use none, new, moderate or strict",
+			cl.Name, s.Level,
+		)
+	}
+	return nil
+}
+
+var computeOn = map[string]bool{
+	string(driver.ComputeOnInsert):  true,
+	string(driver.ComputeOnUpdate):  true,
+	string(driver.ComputeOnReplace): true,
+}
+
+// Arango fills these in itself, so they can't be computed.
+var systemAttributes = map[string]bool{
+	"_key": true, "_id": true, "_rev": true, "_from": true, "_to": true,
+}
+
+func (cl Collection) validateComputedValues() error {
+	seen := map[string]bool{}
+	for _, cv := range cl.ComputedValues {
+		if cv.Name == "" {
+			return errors.Errorf("Collection '%s' has a computed value without a name", cl.Name)
+		}
+		if systemAttributes[cv.Name] {
+			return errors.Errorf("Collection '%s' can't compute the system attribute '%s'", cl.Name, cv.Name)
+		}
+		if cl.ShardKeys != nil {
+			for _, key := range *cl.ShardKeys {
+				if strings.TrimSuffix(key, ":") == cv.Name {
+					return errors.Errorf("Collection '%s' can't compute its shard key '%s'", cl.Name, cv.Name)
+				}
+			}
+		}
+		if seen[cv.Name] {
+			return errors.Errorf("Collection '%s' computes '%s' more than once", cl.Name, cv.Name)
+		}
+		seen[cv.Name] = true
+		if strings.TrimSpace(cv.Expression) == "" {
+			return errors.Errorf("Computed value '%s' on collection '%s' needs an expression", cv.Name, cl.Name)
+		}
+		for _, on := range cv.ComputeOn {
+			if !computeOn[on] {
+				return errors.Errorf(
+					"Computed value '%s' on collection '%s' has unknown computeOn '%s', use insert, update or replace",
+					cv.Name, cl.Name, on,
+				)
+			}
+		}
+	}
+	return nil
+}
+
+// computedValues has Arango parse each expression, so a typo fails the
+// migration rather than every later write.
+func (cl Collection) computedValues(ctx context.Context, db driver.Database) ([]driver.ComputedValue, error) {
+	var values []driver.ComputedValue
+	for _, cv := range cl.ComputedValues {
+		if err := db.ValidateQuery(ctx, cv.Expression); e(err) {
+			return nil, errors.Wrapf(
+				err, "Computed value '%s' on collection '%s' has an invalid expression", cv.Name, cl.Name,
+			)
+		}
+		value := driver.ComputedValue{
+			Name:          cv.Name,
+			Expression:    cv.Expression,
+			Overwrite:     cv.Overwrite,
+			FailOnWarning: cv.FailOnWarning,
+			KeepNull:      cv.KeepNull,
+		}
+		for _, on := range cv.ComputeOn {
+			value.ComputeOn = append(value.ComputeOn, driver.ComputeOn(on))
+		}
+		values = append(values, value)
+	}
+	return values, nil
+}
+
+// references points at the schema's rule file so its contents count towards
+// the migration's checksum.
+func (cl *Collection) references() []*string {
+	if cl.Schema == nil {
+		return nil
+	}
+	return []*string{&cl.Schema.RuleFile}
+}
+
+// options converts the schema into what the driver sends to Arango.
+func (s Schema) options() (*driver.CollectionSchemaOptions, error) {
+	options := driver.CollectionSchemaOptions{
+		Level:   driver.CollectionSchemaLevel(s.Level),
+		Message: s.Message,
+	}
+	if s.RuleFile != "" {
+		bytes, _, err := open(s.RuleFile)
+		if e(err) {
+			return nil, errors.Wrapf(err, "Couldn't read schema rule '%s'", s.RuleFile)
+		}
+		if err := options.LoadRule(bytes); e(err) {
+			return nil, errors.Wrapf(err, "Schema rule '%s' isn't valid JSON", s.RuleFile)
+		}
+	} else {
+		options.Rule = jsonable(s.Rule)
+	}
+	return &options, nil
+}
+
+// jsonable turns the maps YAML produces, which are keyed by interface{},
+// into maps JSON can encode.
+func jsonable(v interface{}) interface{} {
+	switch t := v.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(t))
+		for k, val := range t {
+			m[fmt.Sprint(k)] = jsonable(val)
+		}
+		return m
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(t))
+		for k, val := range t {
+			m[k] = jsonable(val)
+		}
+		return m
+	case []interface{}:
+		l := make([]interface{}, len(t))
+		for i, val := range t {
+			l[i] = jsonable(val)
+		}
+		return l
+	default:
+		return v
+	}
+}
+
 func (cl Collection) Migrate(ctx context.Context, db driver.Database, _ map[string]interface{}) error {
 	switch cl.Action {
 	case CREATE:
@@ -268,6 +900,41 @@ func (cl Collection) Migrate(ctx context.Context, db driver.Database, _ map[stri
 		if cl.Volatile != nil {
 			options.IsVolatile = *cl.Volatile
 		}
+		if cl.NumberOfShards != nil {
+			options.NumberOfShards = *cl.NumberOfShards
+		}
+		if cl.ReplicationFactor != nil {
+			options.ReplicationFactor = int(*cl.ReplicationFactor)
+		}
+		if cl.WriteConcern != nil {
+			options.WriteConcern = *cl.WriteConcern
+		}
+		if cl.DistributeShardsLike != nil {
+			options.DistributeShardsLike = *cl.DistributeShardsLike
+		}
+		if cl.ShardingStrategy != nil {
+			options.ShardingStrategy = driver.ShardingStrategy(*cl.ShardingStrategy)
+		}
+		if cl.SmartJoinAttribute != nil {
+			options.SmartJoinAttribute = *cl.SmartJoinAttribute
+		}
+		if cl.CacheEnabled != nil {
+			options.CacheEnabled = cl.CacheEnabled
+		}
+		if cl.Schema != nil {
+			schema, err := cl.Schema.options()
+			if e(err) {
+				return err
+			}
+			options.Schema = schema
+		}
+		if len(cl.ComputedValues) > 0 {
+			values, err := cl.computedValues(ctx, db)
+			if e(err) {
+				return err
+			}
+			options.ComputedValues = values
+		}
 		if cl.CollectionType != "" {
 			options.Type = driver.CollectionTypeDocument
 			if cl.CollectionType == "edge" {
@@ -284,6 +951,12 @@ func (cl Collection) Migrate(ctx context.Context, db driver.Database, _ map[stri
 			if cl.KeyGeneratorType != nil {
 				ko.Type = driver.KeyGeneratorType(*cl.KeyGeneratorType)
 			}
+			if cl.Increment != nil {
+				ko.Increment = *cl.Increment
+			}
+			if cl.Offset != nil {
+				ko.Offset = *cl.Offset
+			}
 			options.KeyOptions = &ko
 		}
 
@@ -314,8 +987,52 @@ func (cl Collection) Migrate(ctx context.Context, db driver.Database, _ map[stri
 		if cl.WaitForSync != nil {
 			options.WaitForSync = cl.WaitForSync
 		}
+		if cl.ReplicationFactor != nil {
+			options.ReplicationFactor = int(*cl.ReplicationFactor)
+		}
+		if cl.WriteConcern != nil {
+			options.WriteConcern = *cl.WriteConcern
+		}
+		if cl.CacheEnabled != nil {
+			options.CacheEnabled = cl.CacheEnabled
+		}
+		if cl.Schema != nil {
+			schema, err := cl.Schema.options()
+			if e(err) {
+				return err
+			}
+			options.Schema = schema
+		}
+		if len(cl.ComputedValues) > 0 {
+			values, err := cl.computedValues(ctx, db)
+			if e(err) {
+				return err
+			}
+			options.ComputedValues = values
+		}
 		err = col.SetProperties(ctx, options)
 		return errors.Wrapf(err, "Couldn't update collection '%s'", col.Name())
+	case RENAME:
+		col, err := db.Collection(ctx, cl.Name)
+		if e(err) {
+			return errors.Wrapf(err, "Couldn't find collection '%s' to rename", cl.Name)
+		}
+		log.Printf(
+			"Renaming collection '%s' to '%s'. Arango can't rename collections in a cluster.\n",
+			cl.Name, cl.NewName,
+		)
+		err = col.Rename(ctx, cl.NewName)
+		return errors.Wrapf(err, "Couldn't rename collection '%s' to '%s'", cl.Name, cl.NewName)
+	case TRUNCATE:
+		col, err := db.Collection(ctx, cl.Name)
+		if e(err) {
+			return errors.Wrapf(err, "Couldn't find collection '%s' to truncate", cl.Name)
+		}
+		err = col.Truncate(ctx)
+		if !e(err) {
+			log.Printf("Truncated collection '%s'\n", cl.Name)
+		}
+		return errors.Wrapf(err, "Couldn't truncate collection '%s'", cl.Name)
 	}
 
 	return nil
@@ -441,6 +1158,11 @@ func (g Graph) Migrate(ctx context.Context, db driver.Database, _ map[string]int
 	}
 }
 
+// Validate checks what to do about an index with the same definition.
+func (i FullTextIndex) Validate() error {
+	return validateExisting(i.Name, i.Existing)
+}
+
 func (i FullTextIndex) Migrate(ctx context.Context, db driver.Database, _ map[string]interface{}) error {
 	cl, err := db.Collection(ctx, i.Collection)
 	if e(err) {
@@ -451,6 +1173,8 @@ func (i FullTextIndex) Migrate(ctx context.Context, db driver.Database, _ map[st
 		)
 	}
 	switch i.Action {
+	case MODIFY:
+		return modifyIndex(ctx, db, cl, &i, i.Name, false)
 	case DELETE:
 		err = dropIndex(ctx, cl, i.Name)
 		return errors.Wrapf(
@@ -463,18 +1187,34 @@ func (i FullTextIndex) Migrate(ctx context.Context, db driver.Database, _ map[st
 		options.MinLength = i.MinLength
 		options.Name = i.Name
 		options.InBackground = i.InBackground
-		_, _, err = cl.EnsureFullTextIndex(ctx, i.Fields, &options)
-
-		return errors.Wrapf(
-			err,
-			"Could not create full text index with fields '%s' in collection %s",
-			i.Fields, i.Collection,
-		)
+		idx, created, err := cl.EnsureFullTextIndex(ctx, i.Fields, &options)
+		if e(err) {
+			return errors.Wrapf(
+				err,
+				"Could not create full text index with fields '%s' in collection %s",
+				i.Fields, i.Collection,
+			)
+		}
+		return checkExisting(ctx, cl, i.Name, idx.UserName(), created, i.Existing, func(ctx context.Context, name string) error {
+			i.rebuildAs(name)
+			return i.Migrate(ctx, db, nil)
+		})
 	default:
 		return errors.Errorf("Unknown action %s", i.Action)
 	}
 }
 
+// Validate checks legacyPolygons is only set for GeoJSON.
+func (i GeoIndex) Validate() error {
+	if err := validateExisting(i.Name, i.Existing); e(err) {
+		return err
+	}
+	if i.Action != DELETE && i.LegacyPolygons != nil && !i.GeoJSON {
+		return errors.Errorf("Geo index '%s' only takes legacyPolygons with geojson", i.Name)
+	}
+	return nil
+}
+
 func (i GeoIndex) Migrate(ctx context.Context, db driver.Database, _ map[string]interface{}) error {
 	cl, err := db.Collection(ctx, i.Collection)
 	if e(err) {
@@ -485,6 +1225,8 @@ func (i GeoIndex) Migrate(ctx context.Context, db driver.Database, _ map[string]
 		)
 	}
 	switch i.Action {
+	case MODIFY:
+		return modifyIndex(ctx, db, cl, &i, i.Name, false)
 	case DELETE:
 		err = dropIndex(ctx, cl, i.Name)
 		return errors.Wrapf(
@@ -497,19 +1239,30 @@ func (i GeoIndex) Migrate(ctx context.Context, db driver.Database, _ map[string]
 		options.GeoJSON = i.GeoJSON
 		options.Name = i.Name
 		options.InBackground = i.InBackground
-		_, _, err = cl.EnsureGeoIndex(ctx, i.Fields, &options)
-
-		return errors.Wrapf(
-			err,
-			"Could not create geo index with fields '%s' in collection %s",
-			i.Fields, i.Collection,
-		)
+		options.LegacyPolygons = i.LegacyPolygons != nil && *i.LegacyPolygons
+		idx, created, err := cl.EnsureGeoIndex(ctx, i.Fields, &options)
+		if e(err) {
+			return errors.Wrapf(
+				err,
+				"Could not create geo index with fields '%s' in collection %s",
+				i.Fields, i.Collection,
+			)
+		}
+		return checkExisting(ctx, cl, i.Name, idx.UserName(), created, i.Existing, func(ctx context.Context, name string) error {
+			i.rebuildAs(name)
+			return i.Migrate(ctx, db, nil)
+		})
 
 	default:
 		return errors.Errorf("Unknown action %s", i.Action)
 	}
 }
 
+// Validate checks what to do about an index with the same definition.
+func (i HashIndex) Validate() error {
+	return validateExisting(i.Name, i.Existing)
+}
+
 func (i HashIndex) Migrate(ctx context.Context, db driver.Database, _ map[string]interface{}) error {
 	cl, err := db.Collection(ctx, i.Collection)
 
@@ -521,6 +1274,8 @@ func (i HashIndex) Migrate(ctx context.Context, db driver.Database, _ map[string
 		)
 	}
 	switch i.Action {
+	case MODIFY:
+		return modifyIndex(ctx, db, cl, &i, i.Name, false)
 	case DELETE:
 		err = dropIndex(ctx, cl, i.Name)
 		return errors.Wrapf(
@@ -535,18 +1290,59 @@ func (i HashIndex) Migrate(ctx context.Context, db driver.Database, _ map[string
 		options.Unique = i.Unique
 		options.Name = i.Name
 		options.InBackground = i.InBackground
-		_, _, err = cl.EnsureHashIndex(ctx, i.Fields, &options)
-
-		return errors.Wrapf(
-			err,
-			"Could not create hash index with fields '%s' in collection %s",
-			i.Fields, i.Collection,
-		)
+		idx, created, err := cl.EnsureHashIndex(ctx, i.Fields, &options)
+		if e(err) {
+			return errors.Wrapf(
+				err,
+				"Could not create hash index with fields '%s' in collection %s",
+				i.Fields, i.Collection,
+			)
+		}
+		return checkExisting(ctx, cl, i.Name, idx.UserName(), created, i.Existing, func(ctx context.Context, name string) error {
+			i.rebuildAs(name)
+			return i.Migrate(ctx, db, nil)
+		})
 	default:
 		return errors.Errorf("Unknown action %s", i.Action)
 	}
 }
 
+// The most storedValues a persistent index takes.
+const maxStoredValues = 32
+
+// Validate checks the stored values can be kept in the index.
+func (i PersistentIndex) Validate() error {
+	if err := validateExisting(i.Name, i.Existing); e(err) {
+		return err
+	}
+	if i.Action == DELETE {
+		return nil
+	}
+	if len(i.StoredValues) > maxStoredValues {
+		return errors.Errorf(
+			"Persistent index '%s' can store at most %d values, not %d",
+			i.Name, maxStoredValues, len(i.StoredValues),
+		)
+	}
+	indexed := map[string]bool{}
+	for _, f := range i.Fields {
+		indexed[f] = true
+	}
+	stored := map[string]bool{}
+	for _, v := range i.StoredValues {
+		switch {
+		case v == "_id":
+			return errors.Errorf("Persistent index '%s' can't store _id", i.Name)
+		case indexed[v]:
+			return errors.Errorf("Persistent index '%s' already indexes '%s', so it can't store it too", i.Name, v)
+		case stored[v]:
+			return errors.Errorf("Persistent index '%s' stores '%s' twice", i.Name, v)
+		}
+		stored[v] = true
+	}
+	return nil
+}
+
 func (i PersistentIndex) Migrate(ctx context.Context, db driver.Database, _ map[string]interface{}) error {
 	cl, err := db.Collection(ctx, i.Collection)
 	if e(err) {
@@ -557,6 +1353,8 @@ func (i PersistentIndex) Migrate(ctx context.Context, db driver.Database, _ map[
 		)
 	}
 	switch i.Action {
+	case MODIFY:
+		return modifyIndex(ctx, db, cl, &i, i.Name, false)
 	case DELETE:
 		err = dropIndex(ctx, cl, i.Name)
 		return errors.Wrapf(
@@ -570,18 +1368,32 @@ func (i PersistentIndex) Migrate(ctx context.Context, db driver.Database, _ map[
 		options.Unique = i.Unique
 		options.Name = i.Name
 		options.InBackground = i.InBackground
-		_, _, err = cl.EnsurePersistentIndex(ctx, i.Fields, &options)
-
-		return errors.Wrapf(
-			err,
-			"Could not create persistent index with fields '%s' in collection %s",
-			i.Fields, i.Collection,
-		)
+		options.NoDeduplicate = i.Deduplicate != nil && !*i.Deduplicate
+		options.Estimates = i.Estimates
+		options.CacheEnabled = i.CacheEnabled != nil && *i.CacheEnabled
+		options.StoredValues = i.StoredValues
+		idx, created, err := cl.EnsurePersistentIndex(ctx, i.Fields, &options)
+		if e(err) {
+			return errors.Wrapf(
+				err,
+				"Could not create persistent index with fields '%s' in collection %s",
+				i.Fields, i.Collection,
+			)
+		}
+		return checkExisting(ctx, cl, i.Name, idx.UserName(), created, i.Existing, func(ctx context.Context, name string) error {
+			i.rebuildAs(name)
+			return i.Migrate(ctx, db, nil)
+		})
 	default:
 		return errors.Errorf("Unknown action %s", i.Action)
 	}
 }
 
+// Validate checks what to do about an index with the same definition.
+func (i TTLIndex) Validate() error {
+	return validateExisting(i.Name, i.Existing)
+}
+
 func (i TTLIndex) Migrate(ctx context.Context, db driver.Database, _ map[string]interface{}) error {
 	cl, err := db.Collection(ctx, i.Collection)
 	if e(err) {
@@ -592,6 +1404,9 @@ func (i TTLIndex) Migrate(ctx context.Context, db driver.Database, _ map[string]
 		)
 	}
 	switch i.Action {
+	case MODIFY:
+		// A collection takes one TTL index, so the old one goes first.
+		return modifyIndex(ctx, db, cl, &i, i.Name, true)
 	case DELETE:
 		err = dropIndex(ctx, cl, i.Name)
 		return errors.Wrapf(
@@ -603,18 +1418,28 @@ func (i TTLIndex) Migrate(ctx context.Context, db driver.Database, _ map[string]
 		options := driver.EnsureTTLIndexOptions{}
 		options.Name = i.Name
 		options.InBackground = i.InBackground
-		_, _, err = cl.EnsureTTLIndex(ctx, i.Field, i.ExpireAfter, &options)
-
-		return errors.Wrapf(
-			err,
-			"Could not create ttl index with field '%s' in collection %s",
-			i.Field, i.Collection,
-		)
+		idx, created, err := cl.EnsureTTLIndex(ctx, i.Field, i.ExpireAfter, &options)
+		if e(err) {
+			return errors.Wrapf(
+				err,
+				"Could not create ttl index with field '%s' in collection %s",
+				i.Field, i.Collection,
+			)
+		}
+		return checkExisting(ctx, cl, i.Name, idx.UserName(), created, i.Existing, func(ctx context.Context, name string) error {
+			i.rebuildAs(name)
+			return i.Migrate(ctx, db, nil)
+		})
 	default:
 		return errors.Errorf("Unknown action %s", i.Action)
 	}
 }
 
+// Validate checks what to do about an index with the same definition.
+func (i SkiplistIndex) Validate() error {
+	return validateExisting(i.Name, i.Existing)
+}
+
 func (i SkiplistIndex) Migrate(ctx context.Context, db driver.Database, _ map[string]interface{}) error {
 	cl, err := db.Collection(ctx, i.Collection)
 	if e(err) {
@@ -625,6 +1450,8 @@ func (i SkiplistIndex) Migrate(ctx context.Context, db driver.Database, _ map[st
 		)
 	}
 	switch i.Action {
+	case MODIFY:
+		return modifyIndex(ctx, db, cl, &i, i.Name, false)
 	case DELETE:
 		err = dropIndex(ctx, cl, i.Name)
 		return errors.Wrapf(
@@ -639,19 +1466,28 @@ func (i SkiplistIndex) Migrate(ctx context.Context, db driver.Database, _ map[st
 		options.NoDeduplicate = i.NoDeduplicate
 		options.Name = i.Name
 		options.InBackground = i.InBackground
-		_, _, err = cl.EnsureSkipListIndex(ctx, i.Fields, &options)
-
-		return errors.Wrapf(
-			err,
-			"Could not create skiplist index with fields '%s' in collection %s",
-			i.Fields, i.Collection,
-		)
+		idx, created, err := cl.EnsureSkipListIndex(ctx, i.Fields, &options)
+		if e(err) {
+			return errors.Wrapf(
+				err,
+				"Could not create skiplist index with fields '%s' in collection %s",
+				i.Fields, i.Collection,
+			)
+		}
+		return checkExisting(ctx, cl, i.Name, idx.UserName(), created, i.Existing, func(ctx context.Context, name string) error {
+			i.rebuildAs(name)
+			return i.Migrate(ctx, db, nil)
+		})
 
 	default:
 		return errors.Errorf("Unknown action %s", i.Action)
 	}
 }
 
+func (i *PipelineAnalyzer) setClient(cl driver.Client) {
+	i.cl = cl
+}
+
 func (i PipelineAnalyzer) Migrate(ctx context.Context, db driver.Database, _ map[string]interface{}) error {
 	switch i.Action {
 	case DELETE:
@@ -669,6 +1505,16 @@ func (i PipelineAnalyzer) Migrate(ctx context.Context, db driver.Database, _ map
 			Features:   i.Features,
 		})
 		return errors.Wrapf(err, "Failed %s", i.Action)
+	case MODIFY:
+		conn, err := rawConnection(ctx, i.cl)
+		if e(err) {
+			return errors.Wrapf(err, "Analyzer '%s'", i.Name)
+		}
+		return replaceAnalyzer(ctx, conn, db, []driver.Database{db}, i.Name, driver.ArangoSearchAnalyzerDefinition{
+			Type:       "pipeline",
+			Properties: i.Properties,
+			Features:   i.Features,
+		})
 	default:
 		return errors.Errorf("Unknown action %s", i.Action)
 	}
@@ -684,6 +1530,8 @@ func (i InvertedIndex) Migrate(ctx context.Context, db driver.Database, _ map[st
 		)
 	}
 	switch i.Action {
+	case MODIFY:
+		return modifyIndex(ctx, db, cl, &i, i.Name, false)
 	case DELETE:
 		err = dropIndex(ctx, cl, i.Name)
 		return errors.Wrapf(
@@ -692,22 +1540,22 @@ func (i InvertedIndex) Migrate(ctx context.Context, db driver.Database, _ map[st
 			i.Name, i.Collection,
 		)
 	case CREATE:
-		options := driver.InvertedIndexOptions{}
-		options.Name = i.Name
-		options.Fields = i.InvertedIndexFields()
-		options.InBackground = i.InBackground
-		options.Analyzer = i.Analyzer
-		asc := true
-		options.PrimarySort.Fields = []driver.ArangoSearchPrimarySortEntry{
-			{Field: i.Fields[0], Ascending: &asc},
+		conn, err := rawConnection(ctx, i.cl)
+		if e(err) {
+			return errors.Wrapf(err, "Inverted index '%s'", i.Name)
 		}
-		_, _, err = cl.EnsureInvertedIndex(ctx, &options)
-
-		return errors.Wrapf(
-			err,
-			"Could not create inverted index with fields '%s' in collection %s",
-			i.Fields, i.Collection,
-		)
+		found, created, err := ensureIndex(ctx, conn, db, i.Collection, i.body())
+		if e(err) {
+			return errors.Wrapf(
+				err,
+				"Could not create inverted index '%s' in collection %s",
+				i.Name, i.Collection,
+			)
+		}
+		return checkExisting(ctx, cl, i.Name, found, created, i.Existing, func(ctx context.Context, name string) error {
+			i.rebuildAs(name)
+			return i.Migrate(ctx, db, nil)
+		})
 
 	default:
 		return errors.Errorf("Unknown action %s", i.Action)
@@ -737,29 +1585,395 @@ func (a AQL) Migrate(ctx context.Context, db driver.Database, extras map[string]
 	return nil
 }
 
+// dropIndex removes the index or, once modify has replaced it, the latest
+// version modify left of it.
 func dropIndex(ctx context.Context, cl driver.Collection, name string) error {
-	var exists bool
-	var idx driver.Index
-	var err error
-
-	exists, err = cl.IndexExists(ctx, name)
+	idx, _, err := currentIndex(ctx, cl, name)
+	if e(err) || idx == nil {
+		return err
+	}
+	err = idx.Remove(ctx)
 	if e(err) {
-		return errors.Wrapf(err, "Error finding index '%s'", name)
+		return errors.Wrapf(err, "Error dropping index '%s'", idx.UserName())
 	}
+	return nil
+}
 
-	if exists {
-		// get index
-		idx, err = cl.Index(ctx, name)
-		if e(err) {
-			return errors.Wrapf(err, "Error retrieving index '%s'", name)
+// currentIndex finds the index with the name or, when there's none, the
+// latest of the versions modify builds, name_v2, name_v3 and so on. It's nil
+// when there's neither. The version is the highest one on the collection,
+// counting the name itself as 1.
+func currentIndex(ctx context.Context, cl driver.Collection, name string) (driver.Index, int, error) {
+	indexes, err := cl.Indexes(ctx)
+	if e(err) {
+		return nil, 0, errors.Wrapf(err, "Error finding index '%s'", name)
+	}
+	var exact, latest driver.Index
+	version := 0
+	for _, idx := range indexes {
+		v := nameVersion(name, idx.UserName())
+		if v == 1 {
+			exact = idx
+		}
+		if v > version {
+			latest, version = idx, v
 		}
+	}
+	if exact != nil {
+		return exact, version, nil
+	}
+	return latest, version, nil
+}
 
-		// drop index
-		err = idx.Remove(ctx)
-		if e(err) {
-			return errors.Wrapf(err, "Error dropping index '%s'", name)
+// nameVersion is 1 when candidate is name, N when it's name_vN and 0 when
+// it's something else.
+func nameVersion(name, candidate string) int {
+	if candidate == name {
+		return 1
+	}
+	suffix := strings.TrimPrefix(candidate, name+"_v")
+	if suffix == candidate {
+		return 0
+	}
+	if n, err := strconv.Atoi(suffix); err == nil && n > 1 {
+		return n
+	}
+	return 0
+}
+
+// baseIndexName strips the _vN suffix modify gives an index's versions.
+func baseIndexName(name string) string {
+	if i := strings.LastIndex(name, "_v"); i > 0 && nameVersion(name[:i], name) > 1 {
+		return name[:i]
+	}
+	return name
+}
+
+// rebuildable is an index migration that modify can run again as a create.
+type rebuildable interface {
+	Migration
+	// rebuildAs turns the migration into creating the index, in the
+	// background, under the name. Arango handing back an index with the same
+	// definition is for replaceIndex to sort out, so it only warns.
+	rebuildAs(name string)
+}
+
+func (i *FullTextIndex) rebuildAs(name string) {
+	i.Action, i.Name, i.InBackground, i.Existing = CREATE, name, true, ExistingWarn
+}
+
+func (i *GeoIndex) rebuildAs(name string) {
+	i.Action, i.Name, i.InBackground, i.Existing = CREATE, name, true, ExistingWarn
+}
+
+func (i *HashIndex) rebuildAs(name string) {
+	i.Action, i.Name, i.InBackground, i.Existing = CREATE, name, true, ExistingWarn
+}
+
+func (i *PersistentIndex) rebuildAs(name string) {
+	i.Action, i.Name, i.InBackground, i.Existing = CREATE, name, true, ExistingWarn
+}
+
+func (i *TTLIndex) rebuildAs(name string) {
+	i.Action, i.Name, i.InBackground, i.Existing = CREATE, name, true, ExistingWarn
+}
+
+func (i *SkiplistIndex) rebuildAs(name string) {
+	i.Action, i.Name, i.InBackground, i.Existing = CREATE, name, true, ExistingWarn
+}
+
+func (i *InvertedIndex) rebuildAs(name string) {
+	i.Action, i.Name, i.InBackground, i.Existing = CREATE, name, true, ExistingWarn
+}
+
+func (i *MDIIndex) rebuildAs(name string) {
+	i.Action, i.Name, i.InBackground, i.Existing = CREATE, name, true, ExistingWarn
+}
+
+func (i *ZKDIndex) rebuildAs(name string) {
+	i.Action, i.Name, i.InBackground, i.Existing = CREATE, name, true, ExistingWarn
+}
+
+func (i *VectorIndex) rebuildAs(name string) {
+	i.Action, i.Name, i.InBackground, i.Existing = CREATE, name, true, ExistingWarn
+}
+
+// modifyIndex is the modify action of every index migration. index is the
+// Migrate's own copy, so it's safe to change.
+func modifyIndex(
+	ctx context.Context,
+	db driver.Database,
+	cl driver.Collection,
+	index rebuildable,
+	name string,
+	exclusive bool,
+) error {
+	return replaceIndex(ctx, cl, name, exclusive, func(ctx context.Context, next string) (string, error) {
+		ctx, built := recordBuilt(ctx)
+		index.rebuildAs(next)
+		err := index.Migrate(ctx, db, nil)
+		return *built, err
+	})
+}
+
+type builtKey struct{}
+
+// recordBuilt lets checkExisting say which index Arango handed back, which
+// isn't the one asked for when an index already has the definition.
+func recordBuilt(ctx context.Context) (context.Context, *string) {
+	built := new(string)
+	return context.WithValue(ctx, builtKey{}, built), built
+}
+
+func noteBuilt(ctx context.Context, name string) {
+	if built, ok := ctx.Value(builtKey{}).(*string); ok {
+		*built = name
+	}
+}
+
+// replaceIndex changes an index without leaving queries to run without it.
+// Arango can't rename indexes, so build makes the new definition, in the
+// background, as name_v2, name_v3 and so on, returning the name of the index
+// Arango handed back. Once that's finished building the old version is
+// dropped. An exclusive index, one the collection can only have one of, is
+// dropped first and rebuilt under its own name.
+func replaceIndex(
+	ctx context.Context,
+	cl driver.Collection,
+	name string,
+	exclusive bool,
+	build func(ctx context.Context, name string) (string, error),
+) error {
+	if name == "" {
+		return errors.Errorf("Modifying an index on collection %s needs the index's name", cl.Name())
+	}
+	current, version, err := currentIndex(ctx, cl, name)
+	if e(err) {
+		return err
+	}
+	if current == nil {
+		log.Printf("Index '%s' isn't on collection %s yet, creating it\n", name, cl.Name())
+		_, err := build(ctx, name)
+		return err
+	}
+	if exclusive {
+		if err := current.Remove(ctx); e(err) {
+			return errors.Wrapf(err, "Couldn't drop index '%s' to replace it", current.UserName())
 		}
+		_, err := build(ctx, current.UserName())
+		return errors.Wrapf(
+			err, "Dropped index '%s' on collection %s but couldn't build its replacement", current.UserName(), cl.Name(),
+		)
 	}
 
+	next := fmt.Sprintf("%s_v%d", name, version+1)
+	found, err := build(ctx, next)
+	if e(err) {
+		return errors.Wrapf(err, "Couldn't build index '%s' to replace '%s', which is untouched", next, current.UserName())
+	}
+	switch found {
+	case next:
+	case current.UserName():
+		log.Printf("Index '%s' on collection %s already has that definition\n", current.UserName(), cl.Name())
+		return nil
+	default:
+		// Arango hands back an index with the same definition instead.
+		return errors.Errorf(
+			"Index '%s' on collection %s already has the new definition, so '%s' wasn't built and '%s' keeps the old one",
+			found, cl.Name(), next, current.UserName(),
+		)
+	}
+	if err := awaitReplacement(ctx, cl, next); e(err) {
+		return errors.Wrapf(err, "Index '%s' wasn't ready, so '%s' is still there too", next, current.UserName())
+	}
+	if err := current.Remove(ctx); e(err) {
+		return errors.Wrapf(
+			err, "Built index '%s' but couldn't drop '%s', so collection %s has both",
+			next, current.UserName(), cl.Name(),
+		)
+	}
+	log.Printf(
+		"Replaced index '%s' on collection %s. Arango can't rename indexes, so it's now named '%s'\n",
+		current.UserName(), cl.Name(), next,
+	)
 	return nil
 }
+
+// rawConnection finds the connection for the requests the driver has no call
+// for: the client's, or else the one migrateNow keeps in the context.
+func rawConnection(ctx context.Context, cl driver.Client) (driver.Connection, error) {
+	if cl != nil {
+		return cl.Connection(), nil
+	}
+	if w, ok := ctx.Value(indexWaitKey{}).(indexWait); ok && w.conn != nil {
+		return w.conn, nil
+	}
+	return nil, errors.New("Has no connection to Arango, call setClient before running it on its own")
+}
+
+// ensureIndex creates the index unless one with the same definition exists,
+// returning the name of the index Arango handed back and whether it was
+// created. It's for the options the driver can't send.
+func ensureIndex(
+	ctx context.Context,
+	conn driver.Connection,
+	db driver.Database,
+	collection string,
+	body interface{},
+) (string, bool, error) {
+	req, err := conn.NewRequest("POST", path.Join("_db", url.PathEscape(db.Name()), "_api/index"))
+	if e(err) {
+		return "", false, err
+	}
+	req.SetQuery("collection", collection)
+	if _, err := req.SetBody(body); e(err) {
+		return "", false, err
+	}
+	resp, err := conn.Do(ctx, req)
+	if e(err) {
+		return "", false, err
+	}
+	if err := resp.CheckStatus(200, 201); e(err) {
+		return "", false, err
+	}
+	data := struct {
+		Name string `json:"name"`
+	}{}
+	if err := resp.ParseBody("", &data); e(err) {
+		return "", false, err
+	}
+	return data.Name, resp.StatusCode() == 201, nil
+}
+
+// indexDefinition reads the index as Arango has it, with every option,
+// including those the driver drops.
+func indexDefinition(ctx context.Context, conn driver.Connection, db, id string) (map[string]interface{}, error) {
+	req, err := conn.NewRequest("GET", path.Join("_db", url.PathEscape(db), "_api/index", id))
+	if e(err) {
+		return nil, err
+	}
+	resp, err := conn.Do(ctx, req)
+	if e(err) {
+		return nil, err
+	}
+	if err := resp.CheckStatus(200); e(err) {
+		return nil, err
+	}
+	def := map[string]interface{}{}
+	if err := resp.ParseBody("", &def); e(err) {
+		return nil, err
+	}
+	for _, key := range []string{"id", "isNewlyCreated", "error", "code"} {
+		delete(def, key)
+	}
+	return def, nil
+}
+
+// Enumerated values for the indexes' Existing, what to do when Arango already
+// has an index with the same definition under another name.
+const (
+	// ExistingWarn logs the other name and carries on.
+	ExistingWarn = "warn"
+	// ExistingFail stops the migration.
+	ExistingFail = "fail"
+	// ExistingAdopt builds the index again under the migration's name, so
+	// later migrations find it, then drops the other one. When Arango won't
+	// build a copy the other index is dropped first, unless it's unique, and
+	// built again under its old name if the rebuild fails.
+	ExistingAdopt = "adopt"
+)
+
+func validateExisting(name, existing string) error {
+	switch existing {
+	case "", ExistingWarn, ExistingFail, ExistingAdopt:
+		return nil
+	}
+	return errors.Errorf("Index '%s' has unknown existing '%s', use warn, fail or adopt", name, existing)
+}
+
+// checkExisting reports what Ensure*Index did. Arango hands back an index
+// with the same definition rather than making another, whatever it's named.
+func checkExisting(
+	ctx context.Context,
+	cl driver.Collection,
+	requested, found string,
+	created bool,
+	existing string,
+	rebuild func(ctx context.Context, name string) error,
+) error {
+	if found == "" {
+		found = requested
+	}
+	noteBuilt(ctx, found)
+	switch {
+	case created:
+		log.Printf("Created index '%s' on collection %s\n", requested, cl.Name())
+		return awaitIndex(ctx, cl, found)
+	case requested == "" || found == requested:
+		log.Printf("Index '%s' already existed on collection %s\n", found, cl.Name())
+		return nil
+	}
+	switch existing {
+	case ExistingFail:
+		return errors.Errorf(
+			"Index '%s' already existed as '%s' on collection %s, with the same definition",
+			requested, found, cl.Name(),
+		)
+	case ExistingAdopt:
+		idx, err := cl.Index(ctx, found)
+		if e(err) {
+			return errors.Wrapf(err, "Couldn't find index '%s' to adopt it", found)
+		}
+		// Build it under the new name first, so the collection is never
+		// without it.
+		built, name := recordBuilt(ctx)
+		if err := rebuild(built, requested); e(err) {
+			return errors.Wrapf(err, "Couldn't build index '%s' to adopt '%s' on collection %s", requested, found, cl.Name())
+		}
+		if *name == requested {
+			if err := idx.Remove(ctx); e(err) {
+				return errors.Wrapf(err, "Built index '%s' but couldn't drop '%s', which it adopts", requested, found)
+			}
+			noteBuilt(ctx, requested)
+			log.Printf("Adopted index '%s' on collection %s as '%s'\n", found, cl.Name(), requested)
+			return nil
+		}
+		// Arango hands back the index it has rather than build a copy, so
+		// it has to go first. Without a unique index duplicates could get in
+		// while it's rebuilt, and the rebuild would fail on them.
+		if idx.Unique() {
+			return errors.Errorf(
+				"Index '%s' on collection %s is unique and Arango won't build a copy of it as '%s', so it "+
+					"can't be adopted without dropping the constraint while it's rebuilt. It keeps its name",
+				found, cl.Name(), requested,
+			)
+		}
+		if err := idx.Remove(ctx); e(err) {
+			return errors.Wrapf(err, "Couldn't drop index '%s' to adopt it as '%s'", found, requested)
+		}
+		log.Printf("Adopting index '%s' on collection %s as '%s'\n", found, cl.Name(), requested)
+		err = rebuild(ctx, requested)
+		if !e(err) {
+			return nil
+		}
+		// Don't leave the collection without the index it had.
+		if restoreErr := rebuild(ctx, found); e(restoreErr) {
+			return errors.Errorf(
+				"Couldn't adopt index '%s' on collection %s as '%s': %v. Building it again as '%s' failed "+
+					"too, so the collection has neither: %v",
+				found, cl.Name(), requested, err, found, restoreErr,
+			)
+		}
+		return errors.Wrapf(
+			err,
+			"Couldn't adopt index '%s' on collection %s as '%s', so it was built again as '%s'",
+			found, cl.Name(), requested, found,
+		)
+	default:
+		log.Printf(
+			"Index '%s' already existed as '%s' on collection %s, so nothing named '%s' was created\n",
+			requested, found, cl.Name(), requested,
+		)
+		return nil
+	}
+}
@@ -0,0 +1,756 @@
+package arangomigo
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+
+	"github.com/arangodb/go-driver"
+	"github.com/pkg/errors"
+)
+
+// Enumerated values for the Analyzer.Scope
+const (
+	DatabaseScope = "database"
+	SystemScope   = "system"
+)
+
+// analyzerKind lists the properties an analyzer type needs and the ones it
+// may take, by their YAML names.
+type analyzerKind struct {
+	required []string
+	optional []string
+}
+
+var analyzerKinds = map[string]analyzerKind{
+	string(driver.ArangoSearchAnalyzerTypeIdentity): {},
+	string(driver.ArangoSearchAnalyzerTypeText): {
+		required: []string{"locale"},
+		optional: []string{"case", "accent", "stemming", "stopwords", "stopwordspath", "edgengram"},
+	},
+	string(driver.ArangoSearchAnalyzerTypeNorm): {
+		required: []string{"locale"},
+		optional: []string{"case", "accent"},
+	},
+	string(driver.ArangoSearchAnalyzerTypeStem): {
+		required: []string{"locale"},
+	},
+	string(driver.ArangoSearchAnalyzerTypeNGram): {
+		required: []string{"min", "max", "preserveoriginal"},
+		optional: []string{"startmarker", "endmarker", "streamtype"},
+	},
+	string(driver.ArangoSearchAnalyzerTypeDelimiter): {
+		required: []string{"delimiter"},
+	},
+	string(driver.ArangoSearchAnalyzerTypeAQL): {
+		required: []string{"querystring"},
+		optional: []string{"collapsepositions", "keepnull", "batchsize", "memorylimit", "returntype"},
+	},
+	string(driver.ArangoSearchAnalyzerTypePipeline): {
+		required: []string{"pipeline"},
+	},
+	string(driver.ArangoSearchAnalyzerTypeStopwords): {
+		required: []string{"stopwords"},
+		optional: []string{"hex"},
+	},
+	string(driver.ArangoSearchAnalyzerTypeCollation): {
+		required: []string{"locale"},
+	},
+	string(driver.ArangoSearchAnalyzerTypeSegmentation): {
+		optional: []string{"break", "case"},
+	},
+	string(driver.ArangoSearchAnalyzerTypeMinhash): {
+		required: []string{"analyzer", "numhashes"},
+	},
+	string(driver.ArangoSearchAnalyzerTypeClassification): {
+		required: []string{"modellocation"},
+		optional: []string{"topk", "threshold"},
+	},
+	string(driver.ArangoSearchAnalyzerTypeNearestNeighbors): {
+		required: []string{"modellocation"},
+		optional: []string{"topk"},
+	},
+	string(driver.ArangoSearchAnalyzerTypeGeoJSON): {
+		optional: []string{"type", "options"},
+	},
+	string(driver.ArangoSearchAnalyzerTypeGeoPoint): {
+		optional: []string{"latitude", "longitude", "options"},
+	},
+	string(driver.ArangoSearchAnalyzerTypeGeoS2): {
+		optional: []string{"type", "options", "format"},
+	},
+}
+
+// Server versions that introduced the newer analyzer types.
+var analyzerSince = map[string]string{
+	string(driver.ArangoSearchAnalyzerTypePipeline):         ">=3.8",
+	string(driver.ArangoSearchAnalyzerTypeAQL):              ">=3.8",
+	string(driver.ArangoSearchAnalyzerTypeStopwords):        ">=3.8",
+	string(driver.ArangoSearchAnalyzerTypeGeoJSON):          ">=3.8",
+	string(driver.ArangoSearchAnalyzerTypeGeoPoint):         ">=3.8",
+	string(driver.ArangoSearchAnalyzerTypeCollation):        ">=3.9",
+	string(driver.ArangoSearchAnalyzerTypeSegmentation):     ">=3.9",
+	string(driver.ArangoSearchAnalyzerTypeMinhash):          ">=3.10",
+	string(driver.ArangoSearchAnalyzerTypeClassification):   ">=3.10",
+	string(driver.ArangoSearchAnalyzerTypeNearestNeighbors): ">=3.10",
+	string(driver.ArangoSearchAnalyzerTypeGeoS2):            ">=3.10.5",
+}
+
+// Analyzer types only the Enterprise edition has.
+var enterpriseAnalyzers = map[string]bool{
+	string(driver.ArangoSearchAnalyzerTypeMinhash):          true,
+	string(driver.ArangoSearchAnalyzerTypeClassification):   true,
+	string(driver.ArangoSearchAnalyzerTypeNearestNeighbors): true,
+	string(driver.ArangoSearchAnalyzerTypeGeoS2):            true,
+}
+
+var analyzerFeatures = map[driver.ArangoSearchAnalyzerFeature]bool{
+	driver.ArangoSearchAnalyzerFeatureFrequency: true,
+	driver.ArangoSearchAnalyzerFeatureNorm:      true,
+	driver.ArangoSearchAnalyzerFeaturePosition:  true,
+	driver.ArangoSearchAnalyzerFeatureOffset:    true,
+}
+
+// Validate checks the properties suit the analyzer type.
+func (a Analyzer) Validate() error {
+	if a.Scope != "" && a.Scope != DatabaseScope && a.Scope != SystemScope {
+		return errors.Errorf("Analyzer '%s' has unknown scope '%s', use database or system", a.Name, a.Scope)
+	}
+	if a.Scope == SystemScope && a.Database != "" {
+		return errors.Errorf("Analyzer '%s' can't set both a database and the system scope", a.Name)
+	}
+	switch a.Action {
+	case CREATE, MODIFY:
+	case DELETE:
+		return nil
+	default:
+		return errors.Errorf("Analyzer migration does not support action %s", a.Action)
+	}
+	if a.AnalyzerType == "" {
+		return errors.Errorf("Analyzer '%s' needs an analyzerType", a.Name)
+	}
+	if err := validateAnalyzer(a.Name, a.AnalyzerType, a.Properties); e(err) {
+		return err
+	}
+	return validateFeatures(a.Name, a.Features)
+}
+
+// Validate checks the pipeline's steps like any other analyzer.
+func (i PipelineAnalyzer) Validate() error {
+	if i.Action != CREATE && i.Action != MODIFY {
+		return nil
+	}
+	if err := validateAnalyzer(i.Name, string(driver.ArangoSearchAnalyzerTypePipeline), i.Properties); e(err) {
+		return err
+	}
+	return validateFeatures(i.Name, i.Features)
+}
+
+func validateAnalyzer(name, kind string, p driver.ArangoSearchAnalyzerProperties) error {
+	k, ok := analyzerKinds[kind]
+	if !ok {
+		known := make([]string, 0, len(analyzerKinds))
+		for t := range analyzerKinds {
+			known = append(known, t)
+		}
+		sort.Strings(known)
+		return errors.Errorf(
+			"Analyzer '%s' has unknown analyzerType '%s', use one of %s",
+			name, kind, strings.Join(known, ", "),
+		)
+	}
+
+	set := analyzerProperties(p)
+	for _, r := range k.required {
+		if !set[r] {
+			return errors.Errorf("Analyzer '%s' of type %s needs the %s property", name, kind, r)
+		}
+		delete(set, r)
+	}
+	for _, o := range k.optional {
+		delete(set, o)
+	}
+	if extra := setNames(set); len(extra) > 0 {
+		return errors.Errorf(
+			"Analyzer '%s' of type %s doesn't take %s",
+			name, kind, strings.Join(extra, ", "),
+		)
+	}
+
+	switch driver.ArangoSearchAnalyzerType(kind) {
+	case driver.ArangoSearchAnalyzerTypeNGram:
+		if *p.Min < 1 || *p.Max < *p.Min {
+			return errors.Errorf("Analyzer '%s' needs 1 <= min <= max, not min %d and max %d", name, *p.Min, *p.Max)
+		}
+	case driver.ArangoSearchAnalyzerTypeGeoPoint:
+		if len(p.Latitude) > 0 != (len(p.Longitude) > 0) {
+			return errors.Errorf("Analyzer '%s' needs both latitude and longitude, or neither", name)
+		}
+	case driver.ArangoSearchAnalyzerTypeMinhash:
+		if *p.NumHashes < 1 {
+			return errors.Errorf("Analyzer '%s' needs numhashes of at least 1", name)
+		}
+		if err := validateAnalyzer(name, string(p.Analyzer.Type), p.Analyzer.Properties); e(err) {
+			return errors.Wrap(err, "Its inner analyzer is invalid")
+		}
+	case driver.ArangoSearchAnalyzerTypePipeline:
+		for _, step := range p.Pipeline {
+			if step.Type == driver.ArangoSearchAnalyzerTypePipeline {
+				return errors.Errorf("Analyzer '%s' can't nest a pipeline in a pipeline", name)
+			}
+			if err := validateAnalyzer(name, string(step.Type), step.Properties); e(err) {
+				return errors.Wrapf(err, "Pipeline step %s is invalid", step.Type)
+			}
+		}
+	}
+	return nil
+}
+
+func validateFeatures(name string, features []driver.ArangoSearchAnalyzerFeature) error {
+	has := map[driver.ArangoSearchAnalyzerFeature]bool{}
+	for _, f := range features {
+		if !analyzerFeatures[f] {
+			return errors.Errorf(
+				"Analyzer '%s' has unknown feature '%s', use frequency, norm, position or offset",
+				name, f,
+			)
+		}
+		has[f] = true
+	}
+	if has[driver.ArangoSearchAnalyzerFeatureOffset] &&
+		!(has[driver.ArangoSearchAnalyzerFeaturePosition] && has[driver.ArangoSearchAnalyzerFeatureFrequency]) {
+		return errors.Errorf("Analyzer '%s' needs the position and frequency features to use offset", name)
+	}
+	return nil
+}
+
+// analyzerProperties names the properties that are set.
+func analyzerProperties(p driver.ArangoSearchAnalyzerProperties) map[string]bool {
+	set := map[string]bool{
+		"locale":            p.Locale != "",
+		"delimiter":         p.Delimiter != "",
+		"accent":            p.Accent != nil,
+		"case":              p.Case != "",
+		"edgengram":         p.EdgeNGram != nil,
+		"min":               p.Min != nil,
+		"max":               p.Max != nil,
+		"preserveoriginal":  p.PreserveOriginal != nil,
+		"startmarker":       p.StartMarker != nil,
+		"endmarker":         p.EndMarker != nil,
+		"streamtype":        p.StreamType != nil,
+		"stemming":          p.Stemming != nil,
+		"stopwords":         p.Stopwords != nil,
+		"stopwordspath":     p.StopwordsPath != nil,
+		"querystring":       p.QueryString != "",
+		"collapsepositions": p.CollapsePositions != nil,
+		"keepnull":          p.KeepNull != nil,
+		"batchsize":         p.BatchSize != nil,
+		"memorylimit":       p.MemoryLimit != nil,
+		"returntype":        p.ReturnType != nil,
+		"pipeline":          len(p.Pipeline) > 0,
+		"type":              p.Type != nil,
+		"options":           p.Options != nil,
+		"latitude":          len(p.Latitude) > 0,
+		"longitude":         len(p.Longitude) > 0,
+		"break":             p.Break != "",
+		"hex":               p.Hex != nil,
+		"modellocation":     p.ModelLocation != "",
+		"topk":              p.TopK != nil,
+		"threshold":         p.Threshold != nil,
+		"analyzer":          p.Analyzer != nil,
+		"numhashes":         p.NumHashes != nil,
+		"format":            p.Format != nil,
+	}
+	for name, ok := range set {
+		if !ok {
+			delete(set, name)
+		}
+	}
+	return set
+}
+
+func setNames(set map[string]bool) []string {
+	names := make([]string, 0, len(set))
+	for name := range set {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (a *Analyzer) setClient(cl driver.Client) {
+	a.cl = cl
+}
+
+func (a Analyzer) Migrate(ctx context.Context, db driver.Database, _ map[string]interface{}) error {
+	db, err := a.scoped(ctx, db)
+	if e(err) {
+		return err
+	}
+	switch a.Action {
+	case CREATE:
+		existed, _, err := db.EnsureAnalyzer(ctx, driver.ArangoSearchAnalyzerDefinition{
+			Name:       a.Name,
+			Type:       driver.ArangoSearchAnalyzerType(a.AnalyzerType),
+			Properties: a.Properties,
+			Features:   a.Features,
+		})
+		if e(err) {
+			return errors.Wrapf(err, "Couldn't create %s analyzer '%s' in %s", a.AnalyzerType, a.Name, db.Name())
+		}
+		if existed {
+			log.Printf("Analyzer '%s' already existed in %s\n", a.Name, db.Name())
+		} else {
+			log.Printf("Created %s analyzer '%s' in %s\n", a.AnalyzerType, a.Name, db.Name())
+		}
+		return nil
+	case MODIFY:
+		conn, err := rawConnection(ctx, a.cl)
+		if e(err) {
+			return errors.Wrapf(err, "Analyzer '%s'", a.Name)
+		}
+		scan := []driver.Database{db}
+		if a.Scope == SystemScope {
+			if a.cl == nil {
+				return errors.Errorf("Analyzer '%s' needs the client to find the databases using it, call setClient", a.Name)
+			}
+			scan, err = a.cl.AccessibleDatabases(ctx)
+			if e(err) {
+				return errors.Wrapf(err, "Couldn't list the databases that may use analyzer '%s'", a.Name)
+			}
+		}
+		return replaceAnalyzer(ctx, conn, db, scan, a.Name, driver.ArangoSearchAnalyzerDefinition{
+			Type:       driver.ArangoSearchAnalyzerType(a.AnalyzerType),
+			Properties: a.Properties,
+			Features:   a.Features,
+		})
+	case DELETE:
+		an, err := db.Analyzer(ctx, a.Name)
+		if e(err) {
+			return errors.Wrapf(err, "Couldn't find analyzer '%s' in %s to delete", a.Name, db.Name())
+		}
+		err = an.Remove(ctx, true)
+		if !e(err) {
+			log.Printf("Deleted analyzer '%s' from %s\n", a.Name, db.Name())
+		}
+		return errors.Wrapf(err, "Couldn't delete analyzer '%s'", a.Name)
+	default:
+		return errors.Errorf("Unknown action %s", a.Action)
+	}
+}
+
+// TargetDatabase is _system for a system analyzer, so it's recorded there
+// once rather than in every database that migrates.
+func (a *Analyzer) TargetDatabase() string {
+	if a.Scope == SystemScope {
+		return systemDb
+	}
+	return a.Database
+}
+
+// scoped picks the database the analyzer lives in. Analyzers in _system can
+// be used from every database as ::name.
+func (a Analyzer) scoped(ctx context.Context, db driver.Database) (driver.Database, error) {
+	if a.Scope != SystemScope || db.Name() == systemDb {
+		return db, nil
+	}
+	if a.cl == nil {
+		return nil, errors.Errorf("Analyzer '%s' needs the client to open %s, call setClient", a.Name, systemDb)
+	}
+	sys, err := a.cl.Database(ctx, systemDb)
+	return sys, errors.Wrapf(err, "Couldn't open %s for analyzer '%s'", systemDb, a.Name)
+}
+
+// replaceAnalyzer swaps an analyzer for a new definition. Analyzers can't
+// change, so the new one is created as name_v2, name_v3 and so on, every view
+// link and inverted index using the current one is pointed at it, and only
+// then is the old one dropped. The drop isn't forced, so anything left using
+// the old analyzer stops the migration.
+func replaceAnalyzer(
+	ctx context.Context,
+	conn driver.Connection,
+	home driver.Database,
+	scan []driver.Database,
+	name string,
+	def driver.ArangoSearchAnalyzerDefinition,
+) error {
+	current, version, err := currentAnalyzer(ctx, home, name)
+	if e(err) {
+		return err
+	}
+	def.Name = fmt.Sprintf("%s_v%d", name, version+1)
+
+	ref := analyzerRef{database: home.Name(), name: current.Name()}
+	var dependents []analyzerDependent
+	for _, db := range scan {
+		found, err := analyzerDependents(ctx, conn, db, ref)
+		if e(err) {
+			return errors.Wrapf(err, "Couldn't find what uses analyzer '%s' in %s", current.Name(), db.Name())
+		}
+		dependents = append(dependents, found...)
+	}
+
+	if _, _, err := home.EnsureAnalyzer(ctx, def); e(err) {
+		return errors.Wrapf(err, "Couldn't create analyzer '%s' to replace '%s'", def.Name, current.Name())
+	}
+	log.Printf("Created %s analyzer '%s' to replace '%s'\n", def.Type, def.Name, current.Name())
+
+	for i, d := range dependents {
+		if err := d.repoint(ctx, ref, def.Name); e(err) {
+			return errors.Wrapf(
+				err, "Couldn't point %s at analyzer '%s'. Already using it:\n\t%s\nStill using '%s':\n\t%s",
+				d, def.Name, describeDependents(dependents[:i]), current.Name(), describeDependents(dependents[i:]),
+			)
+		}
+		log.Printf("Pointed %s at analyzer '%s'\n", d, def.Name)
+	}
+
+	if err := current.Remove(ctx, false); e(err) {
+		return errors.Wrapf(
+			err, "Couldn't drop analyzer '%s', something the migration can't see still uses it. Repointed:\n\t%s",
+			current.Name(), describeDependents(dependents),
+		)
+	}
+	log.Printf("Replaced analyzer '%s' with '%s' in %s\n", current.Name(), def.Name, home.Name())
+	return nil
+}
+
+// currentAnalyzer finds the latest version of the analyzer. The first one
+// has no suffix and counts as version 1.
+func currentAnalyzer(ctx context.Context, db driver.Database, name string) (driver.ArangoSearchAnalyzer, int, error) {
+	all, err := db.Analyzers(ctx)
+	if e(err) {
+		return nil, 0, errors.Wrapf(err, "Couldn't list the analyzers in %s", db.Name())
+	}
+	var current driver.ArangoSearchAnalyzer
+	version := 0
+	for _, a := range all {
+		if a.UniqueName() != a.Name() && a.UniqueName() != db.Name()+"::"+a.Name() {
+			// Another database's, usually _system's.
+			continue
+		}
+		if v := nameVersion(name, a.Name()); v > version {
+			current, version = a, v
+		}
+	}
+	if current == nil {
+		return nil, 0, errors.Errorf("Couldn't find analyzer '%s' in %s to modify", name, db.Name())
+	}
+	return current, version, nil
+}
+
+// analyzerRef is an analyzer by the database it lives in.
+type analyzerRef struct {
+	database, name string
+}
+
+// matches reports whether a reference from database from, such as text_en,
+// Shop::text_en or ::text_en for _system, names this analyzer.
+func (r analyzerRef) matches(from, reference string) bool {
+	db, name := from, reference
+	if i := strings.LastIndex(reference, "::"); i >= 0 {
+		db, name = reference[:i], reference[i+2:]
+		if db == "" {
+			db = systemDb
+		}
+	}
+	return db == r.database && name == r.name
+}
+
+// swap keeps the reference's prefix, if any, and puts the new name after it.
+func (r analyzerRef) swap(from, reference, to string) string {
+	if !r.matches(from, reference) {
+		return reference
+	}
+	if i := strings.LastIndex(reference, "::"); i >= 0 {
+		return reference[:i+len("::")] + to
+	}
+	return to
+}
+
+func (r analyzerRef) swapAll(from string, references []string, to string) ([]string, bool) {
+	swapped := make([]string, len(references))
+	changed := false
+	for i, reference := range references {
+		swapped[i] = r.swap(from, reference, to)
+		changed = changed || swapped[i] != reference
+	}
+	return swapped, changed
+}
+
+// analyzerDependent is a view or an inverted index that uses the analyzer
+// being replaced.
+type analyzerDependent struct {
+	// conn rebuilds inverted indexes with the options the driver can't send.
+	conn  driver.Connection
+	db    driver.Database
+	view  driver.ArangoSearchView
+	col   driver.Collection
+	index driver.Index
+	// The search-alias views that list the inverted index.
+	aliases []driver.ArangoSearchViewAlias
+}
+
+func (d analyzerDependent) String() string {
+	if d.view != nil {
+		return fmt.Sprintf("view '%s' in %s", d.view.Name(), d.db.Name())
+	}
+	return fmt.Sprintf("inverted index '%s' on %s in %s", d.index.UserName(), d.col.Name(), d.db.Name())
+}
+
+func describeDependents(dependents []analyzerDependent) string {
+	if len(dependents) == 0 {
+		return "nothing"
+	}
+	names := make([]string, len(dependents))
+	for i, d := range dependents {
+		names[i] = d.String()
+	}
+	return strings.Join(names, "\n\t")
+}
+
+// analyzerDependents finds the view links and inverted indexes in db that
+// use the analyzer.
+func analyzerDependents(
+	ctx context.Context,
+	conn driver.Connection,
+	db driver.Database,
+	ref analyzerRef,
+) ([]analyzerDependent, error) {
+	var dependents []analyzerDependent
+	aliases := map[driver.ArangoSearchAliasIndex][]driver.ArangoSearchViewAlias{}
+	views, err := db.Views(ctx)
+	if e(err) {
+		return nil, err
+	}
+	for _, v := range views {
+		switch v.Type() {
+		case driver.ViewTypeArangoSearch:
+			view, err := v.ArangoSearchView()
+			if e(err) {
+				return nil, err
+			}
+			props, err := view.Properties(ctx)
+			if e(err) {
+				return nil, err
+			}
+			for _, link := range props.Links {
+				if _, changed := swapLink(ref, db.Name(), link, ""); changed {
+					dependents = append(dependents, analyzerDependent{db: db, view: view})
+					break
+				}
+			}
+		case driver.ViewTypeArangoSearchAlias:
+			alias, err := v.ArangoSearchViewAlias()
+			if e(err) {
+				return nil, err
+			}
+			props, err := alias.Properties(ctx)
+			if e(err) {
+				return nil, err
+			}
+			for _, index := range props.Indexes {
+				aliases[index] = append(aliases[index], alias)
+			}
+		}
+	}
+
+	cols, err := db.Collections(ctx)
+	if e(err) {
+		return nil, err
+	}
+	for _, col := range cols {
+		indexes, err := col.Indexes(ctx)
+		if e(err) {
+			return nil, err
+		}
+		for _, index := range indexes {
+			if index.Type() != driver.InvertedIndex {
+				continue
+			}
+			if _, changed := swapInverted(ref, db.Name(), index.InvertedIndexOptions(), ""); changed {
+				dependents = append(dependents, analyzerDependent{
+					conn:    conn,
+					db:      db,
+					col:     col,
+					index:   index,
+					aliases: aliases[driver.ArangoSearchAliasIndex{Collection: col.Name(), Index: index.UserName()}],
+				})
+			}
+		}
+	}
+	return dependents, nil
+}
+
+// repoint moves the view or index onto the new analyzer. An inverted index
+// can't change, so a new version is built next to it, swapped into its
+// search-alias views, and only then is the old one dropped.
+func (d analyzerDependent) repoint(ctx context.Context, ref analyzerRef, to string) error {
+	if d.view != nil {
+		props, err := d.view.Properties(ctx)
+		if e(err) {
+			return err
+		}
+		for name, link := range props.Links {
+			props.Links[name], _ = swapLink(ref, d.db.Name(), link, to)
+		}
+		return d.view.SetProperties(ctx, props)
+	}
+
+	// The driver's options have no cache settings, so the new version is
+	// built from the definition Arango has.
+	def, err := indexDefinition(ctx, d.conn, d.db.Name(), d.index.ID())
+	if e(err) {
+		return errors.Wrapf(err, "Couldn't read the definition of index '%s'", d.index.UserName())
+	}
+	swapInvertedDefinition(ref, d.db.Name(), def, to)
+	def["inBackground"] = true
+	old := d.index.UserName()
+	return replaceIndex(ctx, d.col, baseIndexName(old), false, func(ctx context.Context, name string) (string, error) {
+		def["name"] = name
+		found, _, err := ensureIndex(ctx, d.conn, d.db, d.col.Name(), def)
+		if e(err) || found != name {
+			return found, err
+		}
+		err = d.moveAliases(ctx, old, name)
+		if !e(err) {
+			return found, nil
+		}
+		// Don't leave the new version behind, the old one is still in use.
+		idx, dropErr := d.col.Index(ctx, name)
+		if !e(dropErr) {
+			dropErr = idx.Remove(ctx)
+		}
+		if e(dropErr) {
+			return found, errors.Wrapf(err, "Couldn't drop index '%s' either (%s)", name, dropErr)
+		}
+		return found, err
+	})
+}
+
+// moveAliases swaps the old index for the new one in every search-alias view
+// that lists it, putting back the views already changed if one fails.
+func (d analyzerDependent) moveAliases(ctx context.Context, from, to string) error {
+	listed := driver.ArangoSearchAliasIndex{Collection: d.col.Name(), Index: from}
+	var moved []driver.ArangoSearchViewAlias
+	var restore [][]driver.ArangoSearchAliasIndex
+	for _, alias := range d.aliases {
+		props, err := alias.Properties(ctx)
+		if e(err) {
+			return putBackAliases(ctx, moved, restore, err)
+		}
+		swapped := make([]driver.ArangoSearchAliasIndex, len(props.Indexes))
+		for i, index := range props.Indexes {
+			swapped[i] = index
+			if index == listed {
+				swapped[i].Index = to
+			}
+		}
+		if _, err := alias.SetProperties(ctx, driver.ArangoSearchAliasViewProperties{Indexes: swapped}); e(err) {
+			return putBackAliases(ctx, moved, restore, errors.Wrapf(err, "Couldn't swap the index in view '%s'", alias.Name()))
+		}
+		moved = append(moved, alias)
+		restore = append(restore, props.Indexes)
+	}
+	return nil
+}
+
+func putBackAliases(
+	ctx context.Context,
+	aliases []driver.ArangoSearchViewAlias,
+	restore [][]driver.ArangoSearchAliasIndex,
+	cause error,
+) error {
+	for i, alias := range aliases {
+		if _, err := alias.SetProperties(ctx, driver.ArangoSearchAliasViewProperties{Indexes: restore[i]}); e(err) {
+			return errors.Wrapf(cause, "View '%s' still lists the new index, putting it back failed with %s", alias.Name(), err)
+		}
+	}
+	return cause
+}
+
+// swapLink points a view link, and its fields, at the new analyzer.
+func swapLink(
+	ref analyzerRef,
+	from string,
+	link driver.ArangoSearchElementProperties,
+	to string,
+) (driver.ArangoSearchElementProperties, bool) {
+	var changed bool
+	link.Analyzers, changed = ref.swapAll(from, link.Analyzers, to)
+	var c bool
+	link.Fields, c = swapLinkFields(ref, from, link.Fields, to)
+	changed = changed || c
+	link.Nested, c = swapLinkFields(ref, from, link.Nested, to)
+	return link, changed || c
+}
+
+func swapLinkFields(
+	ref analyzerRef,
+	from string,
+	fields driver.ArangoSearchFields,
+	to string,
+) (driver.ArangoSearchFields, bool) {
+	if fields == nil {
+		return nil, false
+	}
+	changed := false
+	swapped := driver.ArangoSearchFields{}
+	for name, field := range fields {
+		var c bool
+		swapped[name], c = swapLink(ref, from, field, to)
+		changed = changed || c
+	}
+	return swapped, changed
+}
+
+// swapInverted points an inverted index, and its fields, at the new analyzer.
+func swapInverted(
+	ref analyzerRef,
+	from string,
+	options driver.InvertedIndexOptions,
+	to string,
+) (driver.InvertedIndexOptions, bool) {
+	changed := ref.matches(from, options.Analyzer)
+	options.Analyzer = ref.swap(from, options.Analyzer, to)
+	var c bool
+	options.Fields, c = swapInvertedFields(ref, from, options.Fields, to)
+	return options, changed || c
+}
+
+// swapInvertedDefinition points an inverted index's definition as Arango
+// returns it, and its fields, at the new analyzer.
+func swapInvertedDefinition(ref analyzerRef, from string, def map[string]interface{}, to string) {
+	if analyzer, ok := def["analyzer"].(string); ok {
+		def["analyzer"] = ref.swap(from, analyzer, to)
+	}
+	for _, key := range []string{"fields", "nested"} {
+		fields, _ := def[key].([]interface{})
+		for _, f := range fields {
+			if field, ok := f.(map[string]interface{}); ok {
+				swapInvertedDefinition(ref, from, field, to)
+			}
+		}
+	}
+}
+
+func swapInvertedFields(
+	ref analyzerRef,
+	from string,
+	fields []driver.InvertedIndexField,
+	to string,
+) ([]driver.InvertedIndexField, bool) {
+	changed := false
+	swapped := make([]driver.InvertedIndexField, len(fields))
+	for i, f := range fields {
+		changed = changed || ref.matches(from, f.Analyzer)
+		f.Analyzer = ref.swap(from, f.Analyzer, to)
+		var c bool
+		f.Nested, c = swapInvertedFields(ref, from, f.Nested, to)
+		changed = changed || c
+		swapped[i] = f
+	}
+	return swapped, changed
+}
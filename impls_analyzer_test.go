@@ -0,0 +1,277 @@
+package arangomigo
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/arangodb/go-driver"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAnalyzerFixturesValidate(t *testing.T) {
+	pms, err := migrations([]string{"testdata/analyzers"})
+	assert.NoError(t, err)
+	assert.Len(t, pms, 5)
+	assert.NoError(t, validate(pms, nil))
+
+	minhash := pms[3].change.(*Analyzer)
+	assert.Equal(t, driver.ArangoSearchAnalyzerTypeSegmentation, minhash.Properties.Analyzer.Type)
+	assert.Equal(t, driver.ArangoSearchBreakTypeAlpha, minhash.Properties.Analyzer.Properties.Break)
+	assert.Equal(t, []Requirements{{Server: ">=3.10"}, {Edition: Enterprise}}, requirementsOf(minhash))
+}
+
+func TestAnalyzerWrappingPipeline(t *testing.T) {
+	m := parseMigration(t, `type: analyzer
+action: create
+name: similar_words
+analyzerType: minhash
+properties:
+  numhashes: 10
+  analyzer:
+    type: pipeline
+    properties:
+      pipeline:
+        - type: norm
+          properties:
+            locale: en
+            case: lower
+`)
+	minhash, ok := m.(*Analyzer)
+	assert.True(t, ok, "Should be an analyzer, not a pipeline")
+	assert.Equal(t, driver.ArangoSearchAnalyzerTypePipeline, minhash.Properties.Analyzer.Type)
+}
+
+func TestAnalyzerValidation(t *testing.T) {
+	invalid := map[string]string{
+		"analyzerType: text\nproperties:\n  case: lower":                                           "Analyzer 'a' of type text needs the locale property",
+		"analyzerType: norm\nproperties:\n  locale: en\n  delimiter: ','":                          "Analyzer 'a' of type norm doesn't take delimiter",
+		"analyzerType: ngram\nproperties:\n  min: 4\n  max: 2\n  preserveoriginal: true":           "Analyzer 'a' needs 1 <= min <= max, not min 4 and max 2",
+		"analyzerType: geopoint\nproperties:\n  latitude: [lat]":                                   "Analyzer 'a' needs both latitude and longitude, or neither",
+		"analyzerType: identity\nfeatures: [offset]":                                               "Analyzer 'a' needs the position and frequency features to use offset",
+		"analyzerType: identity\nfeatures: [speed]":                                                "Analyzer 'a' has unknown feature 'speed', use frequency, norm, position or offset",
+		"analyzerType: identity\nscope: global":                                                    "Analyzer 'a' has unknown scope 'global', use database or system",
+		"analyzerType: identity\nscope: system\ndatabase: Shop":                                    "Analyzer 'a' can't set both a database and the system scope",
+		"properties:\n  locale: en":                                                                "Analyzer 'a' needs an analyzerType",
+		"analyzerType: pipeline\nproperties:\n  pipeline:\n    - type: stem\n      properties: {}": "Pipeline step stem is invalid: Analyzer 'a' of type stem needs the locale property",
+	}
+	for options, msg := range invalid {
+		m := parseMigration(t, "type: analyzer\naction: create\nname: a\n"+options+"\n")
+		assert.EqualError(t, m.(Validator).Validate(), msg)
+	}
+
+	m := parseMigration(t, "type: analyzer\naction: create\nname: a\nanalyzerType: fuzzy\n")
+	assert.Contains(t, m.(Validator).Validate().Error(), "Analyzer 'a' has unknown analyzerType 'fuzzy', use one of aql, classification, collation")
+}
+
+func TestAnalyzerScopes(t *testing.T) {
+	ts, changes := recordingArango(t, map[string]reply{
+		"GET /_db/Shop/_api/database/current":    okReply(`{"result":{"name":"Shop"}}`),
+		"GET /_db/_system/_api/database/current": okReply(`{"result":{"name":"_system"}}`),
+		"POST /_db/Shop/_api/analyzer":           {status: http.StatusCreated, body: `{"name":"Shop::trigram","type":"ngram"}`},
+		"POST /_db/_system/_api/analyzer":        {status: http.StatusCreated, body: `{"name":"norm_de","type":"norm"}`},
+	})
+	defer ts.Close()
+	cl, err := client(Config{Endpoints: []string{ts.URL}})
+	assert.NoError(t, err)
+	db, err := cl.Database(context.Background(), "Shop")
+	assert.NoError(t, err)
+
+	for _, file := range []string{"testdata/analyzers/2_ngram.migration", "testdata/analyzers/3_shared_norm.migration"} {
+		m, err := toStruct(file)
+		assert.NoError(t, err)
+		m.(clientMigration).setClient(cl)
+		assert.NoError(t, m.Migrate(context.Background(), db, nil))
+	}
+	assert.Equal(t, []string{
+		`POST /_db/Shop/_api/analyzer {"name":"trigram","type":"ngram","properties":{"min":3,"max":3,"preserveOriginal":false,"streamType":"utf8","stopwords":null},"error":false,"code":0,"errorNum":0,"errorMessage":""}`,
+		`POST /_db/_system/_api/analyzer {"name":"norm_de","type":"norm","properties":{"locale":"de","accent":false,"case":"lower","stopwords":null},"error":false,"code":0,"errorNum":0,"errorMessage":""}`,
+	}, *changes)
+}
+
+func TestSystemAnalyzerHistory(t *testing.T) {
+	ts, changes := recordingArango(t, map[string]reply{
+		"GET /_db/Shop/_api/database/current":         okReply(`{"result":{"name":"Shop"}}`),
+		"GET /_db/Shop/_api/collection/arangomigo":    okReply(`{"name":"arangomigo"}`),
+		"GET /_db/_system/_api/database/current":      okReply(`{"result":{"name":"_system"}}`),
+		"GET /_db/_system/_api/collection/arangomigo": okReply(`{"name":"arangomigo"}`),
+		"POST /_db/_system/_api/analyzer":             {status: http.StatusCreated, body: `{"name":"norm_de","type":"norm"}`},
+		"POST /_db/_system/_api/document/arangomigo":  {status: http.StatusAccepted, body: `{"_key":"1_step.migration"}`},
+	})
+	defer ts.Close()
+	cl, err := client(Config{Endpoints: []string{ts.URL}})
+	assert.NoError(t, err)
+	db, err := cl.Database(context.Background(), "Shop")
+	assert.NoError(t, err)
+
+	pms := migrationsOf(t, "type: analyzer\naction: create\nname: norm_de\nanalyzerType: norm\nscope: system\n")
+	assert.Equal(t, systemDb, pms[0].change.(targeted).TargetDatabase())
+	pms[0].change.(clientMigration).setClient(cl)
+	_, err = migrateNow(context.Background(), Config{}, cl, db, pms)
+	assert.NoError(t, err)
+	if assert.Len(t, *changes, 2) {
+		assert.Contains(t, (*changes)[0], "POST /_db/_system/_api/analyzer ")
+		assert.Equal(t, `POST /_db/_system/_api/document/arangomigo {"_key":"1_step.migration","Checksum":""}`, (*changes)[1])
+	}
+}
+
+func TestAnalyzerModifyRepointsDependents(t *testing.T) {
+	ts, changes := recordingArango(t, map[string]reply{
+		"GET /_db/Shop/_api/database/current": okReply(`{"result":{"name":"Shop"}}`),
+		"GET /_db/Shop/_api/analyzer": okReply(`{"result":[
+			{"name":"identity","type":"identity"},
+			{"name":"Shop::words","type":"text"},
+			{"name":"Shop::words_v2","type":"text"},
+			{"name":"_system::words_v7","type":"text"}]}`),
+		"POST /_db/Shop/_api/analyzer": {status: http.StatusCreated, body: `{"name":"Shop::words_v3","type":"text"}`},
+		"GET /_db/Shop/_api/view": okReply(`{"result":[
+			{"name":"Search","type":"arangosearch","id":"1"},
+			{"name":"Alias","type":"search-alias","id":"2"}]}`),
+		"GET /_db/Shop/_api/view/Search/properties": okReply(`{"name":"Search","type":"arangosearch",
+			"links":{"products":{"analyzers":["identity"],"fields":{"title":{"analyzers":["Shop::words_v2"]}}}}}`),
+		"PUT /_db/Shop/_api/view/Search/properties": okReply(`{}`),
+		"GET /_db/Shop/_api/view/Alias/properties": okReply(`{"name":"Alias","type":"search-alias",
+			"indexes":[{"collection":"products","index":"byTitle"},{"collection":"products","index":"other"}]}`),
+		"PUT /_db/Shop/_api/view/Alias/properties": okReply(`{}`),
+		"GET /_db/Shop/_api/collection":            okReply(`{"result":[{"name":"products","id":"9"}]}`),
+		"GET /_db/Shop/_api/index": okReply(`{"indexes":[
+			{"id":"products/0","type":"primary","fields":["_key"]},
+			{"id":"products/5","name":"byTitle","type":"inverted","analyzer":"words_v2","fields":[{"name":"title"}]},
+			{"id":"products/6","name":"other","type":"inverted","fields":[{"name":"sku"}]}]}`),
+		"GET /_db/Shop/_api/index/products/5": okReply(`{"id":"products/5","name":"byTitle","type":"inverted",
+			"analyzer":"words_v2","cache":true,"primaryKeyCache":true,"fields":[{"name":"title","cache":false}],
+			"error":false,"code":200}`),
+		"DELETE /_db/Shop/_api/index/products/5":       okReply(`{}`),
+		"POST /_db/Shop/_api/index":                    {status: http.StatusCreated, body: `{"id":"products/8","name":"byTitle_v2","type":"inverted"}`},
+		"GET /_db/Shop/_api/index/products/byTitle_v2": okReply(`{"id":"products/8","name":"byTitle_v2","type":"inverted"}`),
+		"DELETE /_db/Shop/_api/analyzer/words_v2":      okReply(`{}`),
+	})
+	defer ts.Close()
+	cl, err := client(Config{Endpoints: []string{ts.URL}})
+	assert.NoError(t, err)
+	db, err := cl.Database(context.Background(), "Shop")
+	assert.NoError(t, err)
+
+	m := parseMigration(t, "type: analyzer\naction: modify\nname: words\nanalyzerType: text\nproperties:\n  locale: en\n")
+	assert.NoError(t, m.(Validator).Validate())
+	m.(clientMigration).setClient(cl)
+	assert.NoError(t, m.Migrate(context.Background(), db, nil))
+
+	// The index's new version is built and swapped into the view before the
+	// old one goes.
+	assert.Len(t, *changes, 6)
+	assert.Contains(t, (*changes)[0], `POST /_db/Shop/_api/analyzer {"name":"words_v3","type":"text"`)
+	assert.Contains(t, (*changes)[1], `PUT /_db/Shop/_api/view/Search/properties`)
+	assert.Contains(t, (*changes)[1], `"links":{"products":{"analyzers":["identity"],"fields":{"title":{"analyzers":["Shop::words_v3"]}}}}`)
+	assert.Contains(t, (*changes)[2], `POST /_db/Shop/_api/index {`)
+	assert.Contains(t, (*changes)[2], `"name":"byTitle_v2"`)
+	assert.Contains(t, (*changes)[2], `"analyzer":"words_v3"`)
+	assert.Contains(t, (*changes)[2], `"inBackground":true`)
+	assert.Contains(t, (*changes)[2], `"cache":true,"fields":[{"cache":false,"name":"title"}]`)
+	assert.Contains(t, (*changes)[2], `"primaryKeyCache":true`)
+	assert.NotContains(t, (*changes)[2], `"id"`)
+	assert.Contains(t, (*changes)[3], `PUT /_db/Shop/_api/view/Alias/properties`)
+	assert.Contains(t, (*changes)[3], `"indexes":[{"collection":"products","index":"byTitle_v2"},{"collection":"products","index":"other"}]}`)
+	assert.Equal(t, `DELETE /_db/Shop/_api/index/products/5`, (*changes)[4])
+	assert.Equal(t, `DELETE /_db/Shop/_api/analyzer/words_v2`, (*changes)[5])
+}
+
+func TestAnalyzerModifyWithoutClient(t *testing.T) {
+	ts, changes := recordingArango(t, map[string]reply{
+		"GET /_db/Shop/_api/database/current": okReply(`{"result":{"name":"Shop"}}`),
+	})
+	defer ts.Close()
+	cl, err := client(Config{Endpoints: []string{ts.URL}})
+	assert.NoError(t, err)
+	db, err := cl.Database(context.Background(), "Shop")
+	assert.NoError(t, err)
+
+	for contents, msg := range map[string]string{
+		"type: pipeline\naction: modify\nname: words\nproperties:\n  pipeline:\n    - type: norm\n": "Analyzer 'words': " +
+			"Has no connection to Arango, call setClient before running it on its own",
+		"type: analyzer\naction: modify\nname: words\nanalyzerType: norm\n": "Analyzer 'words': " +
+			"Has no connection to Arango, call setClient before running it on its own",
+		"type: analyzer\naction: modify\nname: words\nanalyzerType: norm\nscope: system\n": "Analyzer 'words' " +
+			"needs the client to open _system, call setClient",
+	} {
+		assert.EqualError(t, parseMigration(t, contents).Migrate(context.Background(), db, nil), msg)
+	}
+	assert.Empty(t, *changes)
+}
+
+func TestAnalyzerModifyFailsWithDependents(t *testing.T) {
+	ts, _ := recordingArango(t, map[string]reply{
+		"GET /_db/Shop/_api/database/current": okReply(`{"result":{"name":"Shop"}}`),
+		"GET /_db/Shop/_api/analyzer":         okReply(`{"result":[{"name":"Shop::words","type":"text"}]}`),
+		"POST /_db/Shop/_api/analyzer":        {status: http.StatusCreated, body: `{"name":"Shop::words_v2","type":"text"}`},
+		"GET /_db/Shop/_api/view":             okReply(`{"result":[{"name":"Search","type":"arangosearch","id":"1"}]}`),
+		"GET /_db/Shop/_api/view/Search/properties": okReply(`{"name":"Search","type":"arangosearch",
+			"links":{"products":{"analyzers":["words"]}}}`),
+		"GET /_db/Shop/_api/collection": okReply(`{"result":[]}`),
+	})
+	defer ts.Close()
+	cl, err := client(Config{Endpoints: []string{ts.URL}})
+	assert.NoError(t, err)
+	db, err := cl.Database(context.Background(), "Shop")
+	assert.NoError(t, err)
+
+	m := parseMigration(t, "type: pipeline\naction: modify\nname: words\nproperties:\n  pipeline:\n    - type: norm\n      properties:\n        locale: en\n")
+	m.(clientMigration).setClient(cl)
+	err = m.Migrate(context.Background(), db, nil)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "Couldn't point view 'Search' in Shop at analyzer 'words_v2'. Already using it:\n\tnothing\nStill using 'words':\n\tview 'Search' in Shop")
+}
+
+func TestAnalyzerRefs(t *testing.T) {
+	local := analyzerRef{database: "Shop", name: "words"}
+	assert.True(t, local.matches("Shop", "words"))
+	assert.True(t, local.matches("Shop", "Shop::words"))
+	assert.False(t, local.matches("Shop", "::words"))
+	assert.False(t, local.matches("Other", "words"))
+	assert.Equal(t, "Shop::words_v2", local.swap("Shop", "Shop::words", "words_v2"))
+	assert.Equal(t, "words_v2", local.swap("Shop", "words", "words_v2"))
+	assert.Equal(t, "identity", local.swap("Shop", "identity", "words_v2"))
+
+	shared := analyzerRef{database: systemDb, name: "words"}
+	assert.True(t, shared.matches("Shop", "::words"))
+	assert.True(t, shared.matches("Shop", "_system::words"))
+	assert.True(t, shared.matches(systemDb, "words"))
+	assert.Equal(t, "::words_v2", shared.swap("Shop", "::words", "words_v2"))
+}
+
+func TestAnalyzerModifyKeepsIndexWhenViewFails(t *testing.T) {
+	ts, changes := recordingArango(t, map[string]reply{
+		"GET /_db/Shop/_api/database/current": okReply(`{"result":{"name":"Shop"}}`),
+		"GET /_db/Shop/_api/analyzer":         okReply(`{"result":[{"name":"Shop::words","type":"text"}]}`),
+		"POST /_db/Shop/_api/analyzer":        {status: http.StatusCreated, body: `{"name":"Shop::words_v2","type":"text"}`},
+		"GET /_db/Shop/_api/view":             okReply(`{"result":[{"name":"Alias","type":"search-alias","id":"2"}]}`),
+		"GET /_db/Shop/_api/view/Alias/properties": okReply(`{"name":"Alias","type":"search-alias",
+			"indexes":[{"collection":"products","index":"byTitle"}]}`),
+		"PUT /_db/Shop/_api/view/Alias/properties": {status: http.StatusInternalServerError, body: `{"error":true,"code":500,"errorNum":4,"errorMessage":"busy"}`},
+		"GET /_db/Shop/_api/collection":            okReply(`{"result":[{"name":"products","id":"9"}]}`),
+		"GET /_db/Shop/_api/index": okReply(`{"indexes":[
+			{"id":"products/5","name":"byTitle","type":"inverted","analyzer":"words","fields":[{"name":"title"}]}]}`),
+		"GET /_db/Shop/_api/index/products/5":          okReply(`{"id":"products/5","name":"byTitle","type":"inverted","analyzer":"words"}`),
+		"POST /_db/Shop/_api/index":                    {status: http.StatusCreated, body: `{"id":"products/8","name":"byTitle_v2","type":"inverted"}`},
+		"GET /_db/Shop/_api/index/products/byTitle_v2": okReply(`{"id":"products/8","name":"byTitle_v2","type":"inverted"}`),
+		"DELETE /_db/Shop/_api/index/products/8":       okReply(`{}`),
+	})
+	defer ts.Close()
+	cl, err := client(Config{Endpoints: []string{ts.URL}})
+	assert.NoError(t, err)
+	db, err := cl.Database(context.Background(), "Shop")
+	assert.NoError(t, err)
+
+	m := parseMigration(t, "type: analyzer\naction: modify\nname: words\nanalyzerType: text\nproperties:\n  locale: en\n")
+	m.(clientMigration).setClient(cl)
+	err = m.Migrate(context.Background(), db, nil)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "Still using 'words':\n\tinverted index 'byTitle' on products in Shop")
+
+	// The old index stays, only the half made version is dropped.
+	assert.Len(t, *changes, 4)
+	assert.Contains(t, (*changes)[1], `POST /_db/Shop/_api/index {`)
+	assert.Contains(t, (*changes)[1], `"name":"byTitle_v2"`)
+	assert.Contains(t, (*changes)[2], `PUT /_db/Shop/_api/view/Alias/properties`)
+	assert.Equal(t, `DELETE /_db/Shop/_api/index/products/8`, (*changes)[3])
+}
@@ -0,0 +1,257 @@
+package arangomigo
+
+import (
+	"github.com/arangodb/go-driver"
+	"github.com/pkg/errors"
+)
+
+var compressions = map[string]bool{
+	string(driver.PrimarySortCompressionLz4):  true,
+	string(driver.PrimarySortCompressionNone): true,
+}
+
+var consolidationPolicies = map[string]bool{
+	string(driver.ArangoSearchConsolidationPolicyTypeTier):       true,
+	string(driver.ArangoSearchConsolidationPolicyTypeBytesAccum): true,
+}
+
+// Validate checks the inverted index's settings before anything connects.
+func (i InvertedIndex) Validate() error {
+	if err := validateExisting(i.Name, i.Existing); e(err) {
+		return err
+	}
+	if i.Action == DELETE {
+		return nil
+	}
+	if len(i.Fields) == 0 && (i.IncludeAllFields == nil || !*i.IncludeAllFields) {
+		return errors.Errorf("Inverted index '%s' needs fields, or includeAllFields", i.Name)
+	}
+	if err := validateInvertedFields(i.Name, i.Fields); e(err) {
+		return err
+	}
+	if err := validateIndexFeatures(i.Name, i.Features); e(err) {
+		return err
+	}
+	if i.PrimarySort != nil {
+		if len(i.PrimarySort.Fields) == 0 {
+			return errors.Errorf("Inverted index '%s' has a primarySort without fields", i.Name)
+		}
+		for _, f := range i.PrimarySort.Fields {
+			if f.Field == "" {
+				return errors.Errorf("Inverted index '%s' has a primarySort field without a name", i.Name)
+			}
+		}
+		if err := validateCompression(i.Name, "primarySort", i.PrimarySort.Compression); e(err) {
+			return err
+		}
+	}
+	for _, v := range i.StoredValues {
+		if len(v.Fields) == 0 {
+			return errors.Errorf("Inverted index '%s' has storedValues without fields", i.Name)
+		}
+		if err := validateCompression(i.Name, "storedValues", v.Compression); e(err) {
+			return err
+		}
+	}
+	if i.ConsolidationPolicy != nil && !consolidationPolicies[i.ConsolidationPolicy.Type] {
+		return errors.Errorf(
+			"Inverted index '%s' has unknown consolidationPolicy type '%s', use tier or bytes_accum",
+			i.Name, i.ConsolidationPolicy.Type,
+		)
+	}
+	if i.Parallelism != nil && *i.Parallelism < 1 {
+		return errors.Errorf("Inverted index '%s' needs a parallelism of at least 1", i.Name)
+	}
+	return nil
+}
+
+func validateInvertedFields(index string, fields []InvertedField) error {
+	seen := map[string]bool{}
+	for _, f := range fields {
+		if f.Name == "" {
+			return errors.Errorf("Inverted index '%s' has a field without a name", index)
+		}
+		if seen[f.Name] {
+			return errors.Errorf("Inverted index '%s' lists field '%s' twice", index, f.Name)
+		}
+		seen[f.Name] = true
+		if err := validateIndexFeatures(index, f.Features); e(err) {
+			return errors.Wrapf(err, "Field '%s' is invalid", f.Name)
+		}
+		if err := validateInvertedFields(index, f.Nested); e(err) {
+			return errors.Wrapf(err, "Field '%s' has an invalid nested field", f.Name)
+		}
+	}
+	return nil
+}
+
+func validateIndexFeatures(index string, features []driver.ArangoSearchAnalyzerFeature) error {
+	for _, f := range features {
+		if !analyzerFeatures[f] {
+			return errors.Errorf(
+				"Inverted index '%s' has unknown feature '%s', use frequency, norm, position or offset",
+				index, f,
+			)
+		}
+	}
+	return nil
+}
+
+func validateCompression(index, option, compression string) error {
+	if compression != "" && !compressions[compression] {
+		return errors.Errorf(
+			"Inverted index '%s' has unknown %s compression '%s', use lz4 or none",
+			index, option, compression,
+		)
+	}
+	return nil
+}
+
+// nested reports whether any field indexes nested objects, an Enterprise
+// feature.
+func (i InvertedIndex) nested() bool {
+	var walk func([]InvertedField) bool
+	walk = func(fields []InvertedField) bool {
+		for _, f := range fields {
+			if len(f.Nested) > 0 || walk(f.Nested) {
+				return true
+			}
+		}
+		return false
+	}
+	return walk(i.Fields)
+}
+
+// cached reports whether any of the index's cache options is set, an
+// Enterprise feature.
+func (i InvertedIndex) cached() bool {
+	if i.Cache != nil || i.PrimaryKeyCache != nil || (i.PrimarySort != nil && i.PrimarySort.Cache != nil) {
+		return true
+	}
+	for _, v := range i.StoredValues {
+		if v.Cache != nil {
+			return true
+		}
+	}
+	var walk func([]InvertedField) bool
+	walk = func(fields []InvertedField) bool {
+		for _, f := range fields {
+			if f.Cache != nil || walk(f.Nested) {
+				return true
+			}
+		}
+		return false
+	}
+	return walk(i.Fields)
+}
+
+func (i *InvertedIndex) setClient(cl driver.Client) {
+	i.cl = cl
+}
+
+// invertedIndexBody is what POST /_api/index takes for an inverted index. The
+// driver's InvertedIndexOptions has no cache options, and can't turn a field's
+// setting off when the index turns it on.
+type invertedIndexBody struct {
+	Type                      string                                  `json:"type"`
+	Name                      string                                  `json:"name,omitempty"`
+	InBackground              bool                                    `json:"inBackground,omitempty"`
+	Parallelism               *int                                    `json:"parallelism,omitempty"`
+	Analyzer                  string                                  `json:"analyzer,omitempty"`
+	Features                  []driver.ArangoSearchAnalyzerFeature    `json:"features,omitempty"`
+	IncludeAllFields          *bool                                   `json:"includeAllFields,omitempty"`
+	TrackListPositions        *bool                                   `json:"trackListPositions,omitempty"`
+	SearchField               *bool                                   `json:"searchField,omitempty"`
+	Cache                     *bool                                   `json:"cache,omitempty"`
+	PrimaryKeyCache           *bool                                   `json:"primaryKeyCache,omitempty"`
+	Fields                    []invertedFieldBody                     `json:"fields,omitempty"`
+	PrimarySort               *invertedPrimarySortBody                `json:"primarySort,omitempty"`
+	StoredValues              []driver.StoredValue                    `json:"storedValues,omitempty"`
+	OptimizeTopK              []string                                `json:"optimizeTopK,omitempty"`
+	CleanupIntervalStep       *int64                                  `json:"cleanupIntervalStep,omitempty"`
+	CommitIntervalMsec        *int64                                  `json:"commitIntervalMsec,omitempty"`
+	ConsolidationIntervalMsec *int64                                  `json:"consolidationIntervalMsec,omitempty"`
+	ConsolidationPolicy       *driver.ArangoSearchConsolidationPolicy `json:"consolidationPolicy,omitempty"`
+	WriteBufferIdle           *int64                                  `json:"writebufferIdle,omitempty"`
+	WriteBufferActive         *int64                                  `json:"writebufferActive,omitempty"`
+	WriteBufferSizeMax        *int64                                  `json:"writebufferSizeMax,omitempty"`
+}
+
+type invertedFieldBody struct {
+	Name               string                               `json:"name"`
+	Analyzer           string                               `json:"analyzer,omitempty"`
+	Features           []driver.ArangoSearchAnalyzerFeature `json:"features,omitempty"`
+	IncludeAllFields   *bool                                `json:"includeAllFields,omitempty"`
+	TrackListPositions *bool                                `json:"trackListPositions,omitempty"`
+	SearchField        *bool                                `json:"searchField,omitempty"`
+	Cache              *bool                                `json:"cache,omitempty"`
+	Nested             []invertedFieldBody                  `json:"nested,omitempty"`
+}
+
+type invertedPrimarySortBody struct {
+	Fields      []driver.ArangoSearchPrimarySortEntry `json:"fields"`
+	Compression string                                `json:"compression,omitempty"`
+	Cache       *bool                                 `json:"cache,omitempty"`
+}
+
+func (i InvertedIndex) body() invertedIndexBody {
+	body := invertedIndexBody{
+		Type:                      string(driver.InvertedIndex),
+		Name:                      i.Name,
+		InBackground:              i.InBackground,
+		Parallelism:               i.Parallelism,
+		Analyzer:                  i.Analyzer,
+		Features:                  i.Features,
+		IncludeAllFields:          i.IncludeAllFields,
+		TrackListPositions:        i.TrackListPositions,
+		SearchField:               i.SearchField,
+		Cache:                     i.Cache,
+		PrimaryKeyCache:           i.PrimaryKeyCache,
+		Fields:                    invertedFields(i.Fields),
+		OptimizeTopK:              i.OptimizeTopK,
+		CleanupIntervalStep:       i.CleanupIntervalStep,
+		CommitIntervalMsec:        i.CommitIntervalMsec,
+		ConsolidationIntervalMsec: i.ConsolidationIntervalMsec,
+		WriteBufferIdle:           i.WriteBufferIdle,
+		WriteBufferActive:         i.WriteBufferActive,
+		WriteBufferSizeMax:        i.WriteBufferSizeMax,
+	}
+	if i.PrimarySort != nil {
+		body.PrimarySort = &invertedPrimarySortBody{
+			Compression: i.PrimarySort.Compression,
+			Cache:       i.PrimarySort.Cache,
+		}
+		for _, f := range i.PrimarySort.Fields {
+			body.PrimarySort.Fields = append(body.PrimarySort.Fields, buildSortField(f))
+		}
+	}
+	for _, v := range i.StoredValues {
+		body.StoredValues = append(body.StoredValues, driver.StoredValue{
+			Fields:      v.Fields,
+			Compression: driver.PrimarySortCompression(v.Compression),
+			Cache:       v.Cache,
+		})
+	}
+	if i.ConsolidationPolicy != nil {
+		policy := buildSearchConsolidationPolicy(i.ConsolidationPolicy)
+		body.ConsolidationPolicy = &policy
+	}
+	return body
+}
+
+func invertedFields(fields []InvertedField) []invertedFieldBody {
+	var bodies []invertedFieldBody
+	for _, f := range fields {
+		bodies = append(bodies, invertedFieldBody{
+			Name:               f.Name,
+			Analyzer:           f.Analyzer,
+			Features:           f.Features,
+			IncludeAllFields:   f.IncludeAllFields,
+			TrackListPositions: f.TrackListPositions,
+			SearchField:        f.SearchField,
+			Cache:              f.Cache,
+			Nested:             invertedFields(f.Nested),
+		})
+	}
+	return bodies
+}
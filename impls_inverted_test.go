@@ -0,0 +1,162 @@
+package arangomigo
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/arangodb/go-driver"
+	"github.com/stretchr/testify/assert"
+)
+
+const fullInverted = `type: invertedindex
+action: create
+name: byText
+collection: products
+analyzer: text_en
+features:
+  - frequency
+  - position
+includeAllFields: false
+searchField: true
+parallelism: 4
+fields:
+  - sku
+  - name: title
+    analyzer: identity
+    searchField: false
+  - name: variants
+    nested:
+      - name: color
+        cache: true
+primarySort:
+  fields:
+    - field: price
+      ascending: false
+  compression: none
+  cache: true
+storedValues:
+  - fields:
+      - title
+      - price
+    compression: lz4
+primaryKeyCache: true
+commitIntervalMsec: 500
+consolidationIntervalMsec: 2000
+consolidationPolicy:
+  type: tier
+  options:
+    segmentsBytesFloor: 1024
+`
+
+func TestInvertedIndexOptions(t *testing.T) {
+	ts, changes := recordingArango(t, map[string]reply{
+		"GET /_db/Shop/_api/database/current":    okReply(`{"result":{"name":"Shop"}}`),
+		"GET /_db/Shop/_api/collection/products": okReply(`{"name":"products"}`),
+		"POST /_db/Shop/_api/index":              {status: http.StatusCreated, body: `{"id":"products/1","name":"byText","type":"inverted"}`},
+	})
+	defer ts.Close()
+	cl, err := client(Config{Endpoints: []string{ts.URL}})
+	assert.NoError(t, err)
+	db, err := cl.Database(context.Background(), "Shop")
+	assert.NoError(t, err)
+
+	m := parseMigration(t, fullInverted)
+	assert.NoError(t, m.(Validator).Validate())
+	m.(clientMigration).setClient(cl)
+	assert.NoError(t, m.Migrate(context.Background(), db, nil))
+	assert.Equal(t, []string{
+		`POST /_db/Shop/_api/index {"type":"inverted","name":"byText","parallelism":4,"analyzer":"text_en",` +
+			`"features":["frequency","position"],"includeAllFields":false,"searchField":true,"primaryKeyCache":true,` +
+			`"fields":[{"name":"sku"},{"name":"title","analyzer":"identity","searchField":false},` +
+			`{"name":"variants","nested":[{"name":"color","cache":true}]}],` +
+			`"primarySort":{"fields":[{"field":"price","asc":false,"direction":"DESC"}],"compression":"none","cache":true},` +
+			`"storedValues":[{"fields":["title","price"],"compression":"lz4"}],` +
+			`"commitIntervalMsec":500,"consolidationIntervalMsec":2000,` +
+			`"consolidationPolicy":{"type":"tier","segmentsBytesFloor":1024}}`,
+	}, *changes)
+}
+
+func TestInvertedIndexWithoutClient(t *testing.T) {
+	ts, changes := recordingArango(t, map[string]reply{
+		"GET /_db/Shop/_api/database/current":    okReply(`{"result":{"name":"Shop"}}`),
+		"GET /_db/Shop/_api/collection/products": okReply(`{"name":"products"}`),
+		"POST /_db/Shop/_api/index":              {status: http.StatusCreated, body: `{"id":"products/1","name":"byText","type":"inverted"}`},
+	})
+	defer ts.Close()
+	cl, err := client(Config{Endpoints: []string{ts.URL}})
+	assert.NoError(t, err)
+	db, err := cl.Database(context.Background(), "Shop")
+	assert.NoError(t, err)
+
+	m := parseMigration(t, fullInverted)
+	assert.EqualError(
+		t,
+		m.Migrate(context.Background(), db, nil),
+		"Inverted index 'byText': Has no connection to Arango, call setClient before running it on its own",
+	)
+	assert.Empty(t, *changes)
+
+	// Run by migrateNow, the connection comes with the context.
+	ctx := withIndexWait(context.Background(), Config{}, cl.Connection())
+	assert.NoError(t, m.Migrate(ctx, db, nil))
+	assert.Len(t, *changes, 1)
+}
+
+func TestInvertedIndexValidation(t *testing.T) {
+	cases := map[string]string{
+		"fields: []\n":            "Inverted index 'i' needs fields, or includeAllFields",
+		"fields:\n  - a\n  - a\n": "Inverted index 'i' lists field 'a' twice",
+		"fields:\n  - name: a\n    features:\n      - shape\n":                           "Field 'a' is invalid: Inverted index 'i' has unknown feature 'shape'",
+		"fields:\n  - name: a\n    nested:\n      - analyzer: x\n":                       "Field 'a' has an invalid nested field: Inverted index 'i' has a field without a name",
+		"fields:\n  - a\nprimarySort:\n  compression: zstd\n  fields:\n    - field: a\n": "unknown primarySort compression 'zstd'",
+		"fields:\n  - a\nprimarySort:\n  fields: []\n":                                   "has a primarySort without fields",
+		"fields:\n  - a\nstoredValues:\n  - compression: lz4\n":                          "has storedValues without fields",
+		"fields:\n  - a\nconsolidationPolicy:\n  type: sometimes\n":                      "unknown consolidationPolicy type 'sometimes'",
+	}
+	for extra, want := range cases {
+		m := parseMigration(t, "type: invertedindex\naction: create\nname: i\ncollection: c\n"+extra)
+		err := m.(Validator).Validate()
+		if assert.Error(t, err, extra) {
+			assert.Contains(t, err.Error(), want)
+		}
+	}
+
+	m := parseMigration(t, "type: invertedindex\naction: create\nname: i\ncollection: c\nincludeAllFields: true\n")
+	assert.NoError(t, m.(Validator).Validate())
+}
+
+func TestInvertedIndexRequirements(t *testing.T) {
+	plain := parseMigration(t, "type: invertedindex\naction: create\nname: i\ncollection: c\nfields:\n  - a\n")
+	assert.Equal(t, []Requirements{{Server: ">=3.10"}}, defaultRequirements(plain))
+
+	full := parseMigration(t, fullInverted)
+	assert.Equal(t, []Requirements{
+		{Server: ">=3.10"},
+		{Edition: Enterprise},
+		{Server: ">=3.10.2", Edition: Enterprise},
+	}, defaultRequirements(full))
+}
+
+func TestInvertedIndexFields(t *testing.T) {
+	m := parseMigration(t, `type: invertedindex
+action: create
+collection: products
+fields:
+  - sku
+  - name: variants
+    analyzer: identity
+    searchField: true
+    nested:
+      - color
+`)
+	assert.Equal(t, []driver.InvertedIndexField{
+		{Name: "sku"},
+		{
+			Name:        "variants",
+			Analyzer:    "identity",
+			SearchField: true,
+			Nested:      []driver.InvertedIndexField{{Name: "color"}},
+		},
+	}, m.(*InvertedIndex).InvertedIndexFields())
+}
@@ -0,0 +1,183 @@
+package arangomigo
+
+import (
+	"context"
+
+	"github.com/arangodb/go-driver"
+	"github.com/pkg/errors"
+)
+
+// Validate checks the index has fields to span.
+func (i MDIIndex) Validate() error {
+	if err := validateExisting(i.Name, i.Existing); e(err) {
+		return err
+	}
+	if i.Action == DELETE {
+		return nil
+	}
+	if len(i.Fields) == 0 {
+		return errors.Errorf("Multi-dimensional index '%s' needs fields", i.Name)
+	}
+	return nil
+}
+
+// Validate checks the prefix fields are set apart from the other fields.
+func (i MDIPrefixedIndex) Validate() error {
+	if err := i.MDIIndex.Validate(); e(err) || i.Action == DELETE {
+		return err
+	}
+	if len(i.PrefixFields) == 0 {
+		return errors.Errorf("Prefixed multi-dimensional index '%s' needs prefixFields", i.Name)
+	}
+	fields := map[string]bool{}
+	for _, f := range i.Fields {
+		fields[f] = true
+	}
+	for _, f := range i.PrefixFields {
+		if fields[f] {
+			return errors.Errorf(
+				"Prefixed multi-dimensional index '%s' can't use '%s' as both a field and a prefix field",
+				i.Name, f,
+			)
+		}
+	}
+	return nil
+}
+
+// Validate checks the index has fields to span.
+func (i ZKDIndex) Validate() error {
+	if err := validateExisting(i.Name, i.Existing); e(err) {
+		return err
+	}
+	if i.Action != DELETE && len(i.Fields) == 0 {
+		return errors.Errorf("ZKD index '%s' needs fields", i.Name)
+	}
+	return nil
+}
+
+func (i MDIIndex) Migrate(ctx context.Context, db driver.Database, _ map[string]interface{}) error {
+	cl, err := db.Collection(ctx, i.Collection)
+	if e(err) {
+		return errors.Wrapf(
+			err,
+			"Couldn't create mdi index on collection '%s'. Collection not found",
+			i.Collection,
+		)
+	}
+	switch i.Action {
+	case MODIFY:
+		return modifyIndex(ctx, db, cl, &i, i.Name, false)
+	case DELETE:
+		err = dropIndex(ctx, cl, i.Name)
+		return errors.Wrapf(
+			err,
+			"Could not drop mdi index with name '%s' in collection %s",
+			i.Name, i.Collection,
+		)
+	case CREATE:
+		idx, created, err := cl.EnsureMDIIndex(ctx, i.Fields, &driver.EnsureMDIIndexOptions{
+			Unique:       i.Unique,
+			Sparse:       i.Sparse,
+			InBackground: i.InBackground,
+			Name:         i.Name,
+			StoredValues: i.StoredValues,
+		})
+		if e(err) {
+			return errors.Wrapf(
+				err,
+				"Could not create mdi index with fields '%s' in collection %s",
+				i.Fields, i.Collection,
+			)
+		}
+		return checkExisting(ctx, cl, i.Name, idx.UserName(), created, i.Existing, func(ctx context.Context, name string) error {
+			i.rebuildAs(name)
+			return i.Migrate(ctx, db, nil)
+		})
+	default:
+		return errors.Errorf("Unknown action %s", i.Action)
+	}
+}
+
+func (i MDIPrefixedIndex) Migrate(ctx context.Context, db driver.Database, _ map[string]interface{}) error {
+	cl, err := db.Collection(ctx, i.Collection)
+	if e(err) {
+		return errors.Wrapf(
+			err,
+			"Couldn't create mdi-prefixed index on collection '%s'. Collection not found",
+			i.Collection,
+		)
+	}
+	switch i.Action {
+	case MODIFY:
+		return modifyIndex(ctx, db, cl, &i, i.Name, false)
+	case DELETE:
+		err = dropIndex(ctx, cl, i.Name)
+		return errors.Wrapf(
+			err,
+			"Could not drop mdi-prefixed index with name '%s' in collection %s",
+			i.Name, i.Collection,
+		)
+	case CREATE:
+		options := driver.EnsureMDIPrefixedIndexOptions{PrefixFields: i.PrefixFields}
+		options.Unique = i.Unique
+		options.Sparse = i.Sparse
+		options.InBackground = i.InBackground
+		options.Name = i.Name
+		options.StoredValues = i.StoredValues
+		idx, created, err := cl.EnsureMDIPrefixedIndex(ctx, i.Fields, &options)
+		if e(err) {
+			return errors.Wrapf(
+				err,
+				"Could not create mdi-prefixed index with fields '%s' and prefix fields '%s' in collection %s",
+				i.Fields, i.PrefixFields, i.Collection,
+			)
+		}
+		return checkExisting(ctx, cl, i.Name, idx.UserName(), created, i.Existing, func(ctx context.Context, name string) error {
+			i.rebuildAs(name)
+			return i.Migrate(ctx, db, nil)
+		})
+	default:
+		return errors.Errorf("Unknown action %s", i.Action)
+	}
+}
+
+func (i ZKDIndex) Migrate(ctx context.Context, db driver.Database, _ map[string]interface{}) error {
+	cl, err := db.Collection(ctx, i.Collection)
+	if e(err) {
+		return errors.Wrapf(
+			err,
+			"Couldn't create zkd index on collection '%s'. Collection not found",
+			i.Collection,
+		)
+	}
+	switch i.Action {
+	case MODIFY:
+		return modifyIndex(ctx, db, cl, &i, i.Name, false)
+	case DELETE:
+		err = dropIndex(ctx, cl, i.Name)
+		return errors.Wrapf(
+			err,
+			"Could not drop zkd index with name '%s' in collection %s",
+			i.Name, i.Collection,
+		)
+	case CREATE:
+		idx, created, err := cl.EnsureZKDIndex(ctx, i.Fields, &driver.EnsureZKDIndexOptions{
+			Unique:       i.Unique,
+			InBackground: i.InBackground,
+			Name:         i.Name,
+		})
+		if e(err) {
+			return errors.Wrapf(
+				err,
+				"Could not create zkd index with fields '%s' in collection %s",
+				i.Fields, i.Collection,
+			)
+		}
+		return checkExisting(ctx, cl, i.Name, idx.UserName(), created, i.Existing, func(ctx context.Context, name string) error {
+			i.rebuildAs(name)
+			return i.Migrate(ctx, db, nil)
+		})
+	default:
+		return errors.Errorf("Unknown action %s", i.Action)
+	}
+}
@@ -0,0 +1,58 @@
+package arangomigo
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMultiDimensionalIndexes(t *testing.T) {
+	ts, changes := recordingArango(t, map[string]reply{
+		"GET /_db/Shop/_api/database/current":  okReply(`{"result":{"name":"Shop"}}`),
+		"GET /_db/Shop/_api/collection/events": okReply(`{"name":"events"}`),
+		"POST /_db/Shop/_api/index":            {status: http.StatusCreated, body: `{"id":"events/1","type":"mdi"}`},
+	})
+	defer ts.Close()
+	cl, err := client(Config{Endpoints: []string{ts.URL}})
+	assert.NoError(t, err)
+	db, err := cl.Database(context.Background(), "Shop")
+	assert.NoError(t, err)
+
+	for _, contents := range []string{
+		"type: mdiindex\naction: create\nname: window\ncollection: events\nfields:\n  - from\n  - to\n" +
+			"sparse: true\nstoredValues:\n  - label\n",
+		"type: mdiprefixedindex\naction: create\nname: tenantWindow\ncollection: events\nfields:\n  - from\n  - to\n" +
+			"prefixFields:\n  - tenant\n",
+		"type: zkdindex\naction: create\nname: legacyWindow\ncollection: events\nfields:\n  - from\n  - to\n",
+	} {
+		m := parseMigration(t, contents)
+		assert.NoError(t, m.(Validator).Validate())
+		assert.NoError(t, m.Migrate(context.Background(), db, nil))
+	}
+	if assert.Len(t, *changes, 3) {
+		assert.Contains(t, (*changes)[0], `{"type":"mdi","fields":["from","to"],"unique":false,"sparse":true,`)
+		assert.Contains(t, (*changes)[0], `"fieldValueTypes":"double","storedValues":["label"]`)
+		assert.Contains(t, (*changes)[1], `{"type":"mdi-prefixed","fields":["from","to"]`)
+		assert.Contains(t, (*changes)[1], `"prefixFields":["tenant"]`)
+		assert.Contains(t, (*changes)[2], `{"type":"zkd","fields":["from","to"]`)
+	}
+}
+
+func TestMultiDimensionalIndexValidation(t *testing.T) {
+	cases := map[string]string{
+		"type: mdiindex\nname: i\nfields: []\n":                                   "Multi-dimensional index 'i' needs fields",
+		"type: mdiprefixedindex\nname: i\nfields:\n  - a\n":                       "Prefixed multi-dimensional index 'i' needs prefixFields",
+		"type: mdiprefixedindex\nname: i\nfields:\n  - a\nprefixFields:\n  - a\n": "Prefixed multi-dimensional index 'i' can't use 'a' as both a field and a prefix field",
+		"type: zkdindex\nname: i\n":                                               "ZKD index 'i' needs fields",
+	}
+	for contents, want := range cases {
+		m := parseMigration(t, contents+"action: create\ncollection: c\n")
+		assert.EqualError(t, m.(Validator).Validate(), want)
+	}
+
+	m := parseMigration(t, "type: mdiprefixedindex\naction: create\ncollection: c\nfields:\n  - a\nprefixFields:\n  - b\n")
+	assert.Equal(t, []Requirements{{Server: ">=3.12"}}, defaultRequirements(m))
+	assert.Equal(t, []string{"c"}, m.(collectionUser).usesCollections())
+}
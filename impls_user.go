@@ -0,0 +1,199 @@
+package arangomigo
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/arangodb/go-driver"
+	"github.com/pkg/errors"
+)
+
+var grants = map[string]bool{
+	string(driver.GrantReadWrite): true,
+	string(driver.GrantReadOnly):  true,
+	string(driver.GrantNone):      true,
+}
+
+// Validate checks the user's settings before anything connects.
+func (u UserAccount) Validate() error {
+	if u.Name == "" {
+		return errors.New("A user migration needs the user's name")
+	}
+	switch u.Action {
+	case CREATE, MODIFY:
+	case DELETE:
+		if u.Password != nil || u.Active != nil || u.Extra != nil || len(u.Grants) > 0 || len(u.Revokes) > 0 {
+			return errors.Errorf(
+				"User '%s' is being deleted, so it can't set a password, active, extra, grants or revokes",
+				u.Name,
+			)
+		}
+	default:
+		return errors.Errorf("User migration does not support action %s", u.Action)
+	}
+	for _, p := range u.Grants {
+		if !grants[p.Grant] {
+			return errors.Errorf("User '%s' has unknown grant '%s', use rw, ro or none", u.Name, p.Grant)
+		}
+	}
+	for _, p := range u.Revokes {
+		if p.Grant != "" {
+			return errors.Errorf("User '%s' revokes access to %s, so it can't set a grant", u.Name, p.describe(""))
+		}
+	}
+	return nil
+}
+
+func (u *UserAccount) setClient(cl driver.Client) {
+	u.cl = cl
+}
+
+// usesCollections lists the collections the permissions point at in the
+// database being migrated.
+func (u *UserAccount) usesCollections() []string {
+	var names []string
+	for _, p := range append(append([]Permission{}, u.Grants...), u.Revokes...) {
+		if p.Collection != "" && p.Database == "" {
+			names = append(names, p.Collection)
+		}
+	}
+	return names
+}
+
+func (u UserAccount) Migrate(ctx context.Context, db driver.Database, extras map[string]interface{}) error {
+	switch u.Action {
+	case CREATE:
+		options, err := u.options(extras)
+		if e(err) {
+			return err
+		}
+		user, err := u.cl.CreateUser(ctx, u.Name, &options)
+		if e(err) {
+			return errors.Wrapf(err, "Couldn't create user '%s'", u.Name)
+		}
+		log.Printf("Created user '%s'\n", u.Name)
+		return u.permit(ctx, db, user)
+	case MODIFY:
+		user, err := u.cl.User(ctx, u.Name)
+		if e(err) {
+			return errors.Wrapf(err, "Couldn't find user '%s' to modify", u.Name)
+		}
+		if u.Password != nil || u.Active != nil || u.Extra != nil {
+			options, err := u.options(extras)
+			if e(err) {
+				return err
+			}
+			if err := user.Update(ctx, options); e(err) {
+				return errors.Wrapf(err, "Couldn't update user '%s'", u.Name)
+			}
+			log.Printf("Updated user '%s'\n", u.Name)
+		}
+		return u.permit(ctx, db, user)
+	case DELETE:
+		user, err := u.cl.User(ctx, u.Name)
+		if e(err) {
+			return errors.Wrapf(err, "Couldn't find user '%s' to delete", u.Name)
+		}
+		err = user.Remove(ctx)
+		if !e(err) {
+			log.Printf("Deleted user '%s'\n", u.Name)
+		}
+		return errors.Wrapf(err, "Couldn't delete user '%s'", u.Name)
+	default:
+		return errors.Errorf("Unknown action %s", u.Action)
+	}
+}
+
+// options looks the password up in the extras, so it can stay out of source
+// control.
+func (u UserAccount) options(extras map[string]interface{}) (driver.UserOptions, error) {
+	options := driver.UserOptions{Active: u.Active}
+	if u.Password != nil {
+		password, ok := directReplace(*u.Password, extras).(string)
+		if !ok {
+			return options, errors.Errorf("The password of user '%s' must be a string", u.Name)
+		}
+		options.Password = password
+	}
+	if u.Extra != nil {
+		options.Extra = jsonable(u.Extra)
+	}
+	return options, nil
+}
+
+// permit applies the grants, then the revokes, in the order they're written.
+func (u UserAccount) permit(ctx context.Context, db driver.Database, user driver.User) error {
+	for _, p := range u.Grants {
+		target, col, err := p.target(ctx, u.cl, db)
+		if e(err) {
+			return errors.Wrapf(err, "Couldn't find %s to grant user '%s' access", p.describe(db.Name()), u.Name)
+		}
+		if col != nil {
+			err = user.SetCollectionAccess(ctx, col, driver.Grant(p.Grant))
+		} else {
+			err = user.SetDatabaseAccess(ctx, target, driver.Grant(p.Grant))
+		}
+		if e(err) {
+			return errors.Wrapf(
+				err, "Couldn't grant user '%s' %s access to %s", u.Name, p.Grant, p.describe(db.Name()),
+			)
+		}
+		log.Printf("Granted user '%s' %s access to %s\n", u.Name, p.Grant, p.describe(db.Name()))
+	}
+	for _, p := range u.Revokes {
+		target, col, err := p.target(ctx, u.cl, db)
+		if e(err) {
+			return errors.Wrapf(err, "Couldn't find %s to revoke user '%s' access", p.describe(db.Name()), u.Name)
+		}
+		if col != nil {
+			err = user.RemoveCollectionAccess(ctx, col)
+		} else {
+			err = user.RemoveDatabaseAccess(ctx, target)
+		}
+		if e(err) {
+			return errors.Wrapf(err, "Couldn't revoke user '%s' access to %s", u.Name, p.describe(db.Name()))
+		}
+		log.Printf("Revoked user '%s' access to %s\n", u.Name, p.describe(db.Name()))
+	}
+	return nil
+}
+
+// target finds the database and, when the permission names one, the
+// collection it applies to.
+func (p Permission) target(
+	ctx context.Context,
+	cl driver.Client,
+	db driver.Database,
+) (driver.Database, driver.Collection, error) {
+	if p.Database != "" && p.Database != db.Name() {
+		other, err := cl.Database(ctx, p.Database)
+		if e(err) {
+			return nil, nil, err
+		}
+		db = other
+	}
+	if p.Collection == "" {
+		return db, nil, nil
+	}
+	col, err := db.Collection(ctx, p.Collection)
+	if e(err) {
+		return nil, nil, err
+	}
+	return db, col, nil
+}
+
+// describe names what the permission applies to in log and error messages.
+func (p Permission) describe(current string) string {
+	dbName := p.Database
+	if dbName == "" {
+		dbName = current
+	}
+	if p.Collection == "" {
+		return fmt.Sprintf("database '%s'", dbName)
+	}
+	if dbName == "" {
+		return fmt.Sprintf("collection '%s'", p.Collection)
+	}
+	return fmt.Sprintf("collection '%s' in database '%s'", p.Collection, dbName)
+}
@@ -0,0 +1,113 @@
+package arangomigo
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// The routes to manage user orders in database Shop.
+func userRoutes() map[string]reply {
+	return map[string]reply{
+		"GET /_db/Shop/_api/database/current":          okReply(`{"result":{"name":"Shop"}}`),
+		"GET /_db/Reports/_api/database/current":       okReply(`{"result":{"name":"Reports"}}`),
+		"GET /_db/Shop/_api/collection/orders":         okReply(`{"name":"orders"}`),
+		"GET /_db/Shop/_api/collection/audit":          okReply(`{"name":"audit"}`),
+		"POST /_api/user":                              {status: http.StatusCreated, body: `{"user":"orders","active":true}`},
+		"GET /_api/user/orders":                        okReply(`{"user":"orders","active":true}`),
+		"PATCH /_api/user/orders":                      okReply(`{"user":"orders","active":false}`),
+		"DELETE /_api/user/orders":                     {status: http.StatusAccepted, body: `{}`},
+		"PUT /_api/user/orders/database/Shop":          okReply(`{}`),
+		"PUT /_api/user/orders/database/Reports":       okReply(`{}`),
+		"PUT /_api/user/orders/database/Shop/orders":   okReply(`{}`),
+		"DELETE /_api/user/orders/database/Shop/audit": {status: http.StatusAccepted, body: `{}`},
+	}
+}
+
+// Runs the user migration against the fake server, returning the changes it made.
+func migrateUser(t *testing.T, contents string) ([]string, error) {
+	ts, changes := recordingArango(t, userRoutes())
+	defer ts.Close()
+	cl, err := client(Config{Endpoints: []string{ts.URL}})
+	if e(err) {
+		t.Fatal(err)
+	}
+	db, err := cl.Database(context.Background(), "Shop")
+	if e(err) {
+		t.Fatal(err)
+	}
+
+	m := parseMigration(t, contents)
+	assert.NoError(t, m.(Validator).Validate())
+	m.(clientMigration).setClient(cl)
+	err = m.Migrate(context.Background(), db, map[string]interface{}{"${ordersPassword}": "s3cret"})
+	return *changes, err
+}
+
+func TestCreateUser(t *testing.T) {
+	changes, err := migrateUser(t, `type: user
+action: create
+name: orders
+password: ${ordersPassword}
+extra:
+  team: checkout
+grants:
+  - grant: ro
+  - collection: orders
+    grant: rw
+  - database: Reports
+    grant: ro
+revokes:
+  - collection: audit
+`)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{
+		`POST /_api/user {"passwd":"s3cret","extra":{"team":"checkout"},"user":"orders"}`,
+		`PUT /_api/user/orders/database/Shop {"grant":"ro"}`,
+		`PUT /_api/user/orders/database/Shop/orders {"grant":"rw"}`,
+		`PUT /_api/user/orders/database/Reports {"grant":"ro"}`,
+		`DELETE /_api/user/orders/database/Shop/audit`,
+	}, changes)
+}
+
+func TestModifyUser(t *testing.T) {
+	changes, err := migrateUser(t, `type: user
+action: modify
+name: orders
+active: false
+grants:
+  - grant: none
+`)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{
+		`PATCH /_api/user/orders {"active":false}`,
+		`PUT /_api/user/orders/database/Shop {"grant":"none"}`,
+	}, changes)
+}
+
+func TestDeleteUser(t *testing.T) {
+	changes, err := migrateUser(t, "type: user\naction: delete\nname: orders\n")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"DELETE /_api/user/orders"}, changes)
+}
+
+func TestUserGrantFailure(t *testing.T) {
+	_, err := migrateUser(t, "type: user\naction: modify\nname: orders\ngrants:\n  - collection: missing\n    grant: rw\n")
+	assert.Contains(t, err.Error(), "Couldn't find collection 'missing' in database 'Shop' to grant user 'orders' access")
+}
+
+func TestUserValidation(t *testing.T) {
+	invalid := map[string]string{
+		"action: create":                                                       "A user migration needs the user's name",
+		"action: run\nname: u":                                                 "User migration does not support action run",
+		"action: delete\nname: u\npassword: p":                                 "User 'u' is being deleted, so it can't set a password, active, extra, grants or revokes",
+		"action: create\nname: u\ngrants:\n  - grant: admin":                   "User 'u' has unknown grant 'admin', use rw, ro or none",
+		"action: modify\nname: u\nrevokes:\n  - database: Shop\n    grant: rw": "User 'u' revokes access to database 'Shop', so it can't set a grant",
+	}
+	for options, msg := range invalid {
+		m := parseMigration(t, "type: user\n"+options+"\n")
+		assert.EqualError(t, m.(Validator).Validate(), msg)
+	}
+}
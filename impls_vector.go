@@ -0,0 +1,141 @@
+package arangomigo
+
+import (
+	"context"
+
+	"github.com/arangodb/go-driver"
+	"github.com/pkg/errors"
+)
+
+// Enumerated values for the VectorIndex.Metric
+const (
+	CosineMetric       = "cosine"
+	L2Metric           = "l2"
+	InnerProductMetric = "innerProduct"
+)
+
+// Validate checks the vector index's parameters before anything connects.
+func (i VectorIndex) Validate() error {
+	if err := validateExisting(i.Name, i.Existing); e(err) {
+		return err
+	}
+	if i.Action == DELETE {
+		return nil
+	}
+	if i.Field == "" {
+		return errors.Errorf("Vector index '%s' needs the field holding the vectors", i.Name)
+	}
+	switch i.Metric {
+	case CosineMetric, L2Metric, InnerProductMetric:
+	default:
+		return errors.Errorf("Vector index '%s' has unknown metric '%s', use cosine, l2 or innerProduct", i.Name, i.Metric)
+	}
+	if i.Dimension < 1 {
+		return errors.Errorf("Vector index '%s' needs a dimension of at least 1", i.Name)
+	}
+	if i.NLists < 1 {
+		return errors.Errorf("Vector index '%s' needs nLists of at least 1", i.Name)
+	}
+	if i.DefaultNProbe != nil && *i.DefaultNProbe < 1 {
+		return errors.Errorf("Vector index '%s' needs a defaultNProbe of at least 1", i.Name)
+	}
+	if i.TrainingIterations != nil && *i.TrainingIterations < 1 {
+		return errors.Errorf("Vector index '%s' needs trainingIterations of at least 1", i.Name)
+	}
+	if i.Parallelism != nil && *i.Parallelism < 1 {
+		return errors.Errorf("Vector index '%s' needs a parallelism of at least 1", i.Name)
+	}
+	for _, v := range i.StoredValues {
+		if v == i.Field {
+			return errors.Errorf("Vector index '%s' already indexes '%s', so it can't store it too", i.Name, v)
+		}
+	}
+	return nil
+}
+
+func (i *VectorIndex) setClient(cl driver.Client) {
+	i.cl = cl
+}
+
+// vectorIndexBody is what POST /_api/index takes for a vector index, which
+// the driver doesn't know.
+type vectorIndexBody struct {
+	Type         string            `json:"type"`
+	Name         string            `json:"name,omitempty"`
+	Fields       []string          `json:"fields"`
+	InBackground bool              `json:"inBackground,omitempty"`
+	Parallelism  *int              `json:"parallelism,omitempty"`
+	Sparse       *bool             `json:"sparse,omitempty"`
+	StoredValues []string          `json:"storedValues,omitempty"`
+	Params       vectorIndexParams `json:"params"`
+}
+
+type vectorIndexParams struct {
+	Metric             string `json:"metric"`
+	Dimension          int    `json:"dimension"`
+	NLists             int    `json:"nLists"`
+	DefaultNProbe      *int   `json:"defaultNProbe,omitempty"`
+	TrainingIterations *int   `json:"trainingIterations,omitempty"`
+	Factory            string `json:"factory,omitempty"`
+}
+
+func (i VectorIndex) body() vectorIndexBody {
+	return vectorIndexBody{
+		Type:         "vector",
+		Name:         i.Name,
+		Fields:       []string{i.Field},
+		InBackground: i.InBackground,
+		Parallelism:  i.Parallelism,
+		Sparse:       i.Sparse,
+		StoredValues: i.StoredValues,
+		Params: vectorIndexParams{
+			Metric:             i.Metric,
+			Dimension:          i.Dimension,
+			NLists:             i.NLists,
+			DefaultNProbe:      i.DefaultNProbe,
+			TrainingIterations: i.TrainingIterations,
+			Factory:            i.Factory,
+		},
+	}
+}
+
+func (i VectorIndex) Migrate(ctx context.Context, db driver.Database, _ map[string]interface{}) error {
+	cl, err := db.Collection(ctx, i.Collection)
+	if e(err) {
+		return errors.Wrapf(
+			err,
+			"Couldn't create vector index on collection '%s'. Collection not found",
+			i.Collection,
+		)
+	}
+	switch i.Action {
+	case MODIFY:
+		return modifyIndex(ctx, db, cl, &i, i.Name, false)
+	case DELETE:
+		err = dropIndex(ctx, cl, i.Name)
+		return errors.Wrapf(
+			err,
+			"Could not drop vector index with name '%s' in collection %s",
+			i.Name, i.Collection,
+		)
+	case CREATE:
+		conn, err := rawConnection(ctx, i.cl)
+		if e(err) {
+			return errors.Wrapf(err, "Vector index '%s'", i.Name)
+		}
+		found, created, err := ensureIndex(ctx, conn, db, i.Collection, i.body())
+		if e(err) {
+			return errors.Wrapf(
+				err,
+				"Could not create vector index '%s' on field '%s' in collection %s",
+				i.Name, i.Field, i.Collection,
+			)
+		}
+		return checkExisting(ctx, cl, i.Name, found, created, i.Existing, func(ctx context.Context, name string) error {
+			i.rebuildAs(name)
+			return i.Migrate(ctx, db, nil)
+		})
+	default:
+		return errors.Errorf("Unknown action %s", i.Action)
+	}
+}
@@ -0,0 +1,67 @@
+package arangomigo
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVectorIndex(t *testing.T) {
+	ts, changes := recordingArango(t, map[string]reply{
+		"GET /_db/Shop/_api/database/current":    okReply(`{"result":{"name":"Shop"}}`),
+		"GET /_db/Shop/_api/collection/products": okReply(`{"name":"products"}`),
+		"POST /_db/Shop/_api/index":              {status: http.StatusCreated, body: `{"id":"products/1","type":"vector"}`},
+	})
+	defer ts.Close()
+	cl, err := client(Config{Endpoints: []string{ts.URL}})
+	assert.NoError(t, err)
+	db, err := cl.Database(context.Background(), "Shop")
+	assert.NoError(t, err)
+
+	m := parseMigration(t, `type: vectorindex
+action: create
+name: byEmbedding
+collection: products
+field: embedding
+metric: cosine
+dimension: 768
+nLists: 100
+defaultNProbe: 10
+trainingIterations: 25
+inbackground: true
+`)
+	assert.NoError(t, m.(Validator).Validate())
+	m.(clientMigration).setClient(cl)
+	assert.NoError(t, m.Migrate(context.Background(), db, nil))
+	assert.Equal(t, []string{
+		`POST /_db/Shop/_api/index {"type":"vector","name":"byEmbedding","fields":["embedding"],"inBackground":true,` +
+			`"params":{"metric":"cosine","dimension":768,"nLists":100,"defaultNProbe":10,"trainingIterations":25}}`,
+	}, *changes)
+	assert.Equal(t, []Requirements{{Server: ">=3.12.4"}}, defaultRequirements(m))
+
+	// Without a client, or a connection in the context, it fails rather than panics.
+	m = parseMigration(t, "type: vectorindex\naction: create\nname: byEmbedding\ncollection: products\n"+
+		"field: embedding\nmetric: cosine\ndimension: 768\nnLists: 100\n")
+	assert.EqualError(
+		t,
+		m.Migrate(context.Background(), db, nil),
+		"Vector index 'byEmbedding': Has no connection to Arango, call setClient before running it on its own",
+	)
+}
+
+func TestVectorIndexValidation(t *testing.T) {
+	cases := map[string]string{
+		"metric: cosine\ndimension: 3\nnLists: 1\n":                             "Vector index 'v' needs the field holding the vectors",
+		"field: e\nmetric: dot\ndimension: 3\nnLists: 1\n":                      "Vector index 'v' has unknown metric 'dot', use cosine, l2 or innerProduct",
+		"field: e\nmetric: l2\nnLists: 1\n":                                     "Vector index 'v' needs a dimension of at least 1",
+		"field: e\nmetric: l2\ndimension: 3\n":                                  "Vector index 'v' needs nLists of at least 1",
+		"field: e\nmetric: l2\ndimension: 3\nnLists: 1\ndefaultNProbe: 0\n":     "Vector index 'v' needs a defaultNProbe of at least 1",
+		"field: e\nmetric: l2\ndimension: 3\nnLists: 1\nstoredValues:\n  - e\n": "Vector index 'v' already indexes 'e', so it can't store it too",
+	}
+	for extra, want := range cases {
+		m := parseMigration(t, "type: vectorindex\naction: create\nname: v\ncollection: c\n"+extra)
+		assert.EqualError(t, m.(Validator).Validate(), want)
+	}
+}
@@ -24,6 +24,10 @@ type Operation struct {
 	Type     string
 	Name     string
 	Action   Action
+	// Requires lists what the server needs to run this migration.
+	Requires *Requirements `yaml:"requires,omitempty"`
+	// Database runs the migration in another database on the same server.
+	Database string `yaml:"database,omitempty"`
 }
 
 // Action enumerated values for valid operation actions.
@@ -35,6 +39,9 @@ const (
 	DELETE Action = "delete"
 	MODIFY Action = "modify"
 	RUN    Action = "run"
+	// RENAME and TRUNCATE only apply to collections.
+	RENAME   Action = "rename"
+	TRUNCATE Action = "truncate"
 )
 
 // Declares the various patterns for mapping the types.
@@ -49,9 +56,15 @@ var persistentidx = regexp.MustCompile(`^type:\spersistentindex`)
 var ttlidx = regexp.MustCompile(`^type:\sttlindex`)
 var skipidx = regexp.MustCompile(`^type:\sskiplistindex`)
 var invertedidx = regexp.MustCompile(`^type:\sinvertedindex`)
+var mdiidx = regexp.MustCompile(`^type:\smdiindex`)
+var mdiprefixedidx = regexp.MustCompile(`^type:\smdiprefixedindex`)
+var zkdidx = regexp.MustCompile(`^type:\szkdindex`)
+var vectoridx = regexp.MustCompile(`^type:\svectorindex`)
 var view = regexp.MustCompile(`^type:\sview`)
-var pipeline = regexp.MustCompile(`type:\spipeline`)
+var pipeline = regexp.MustCompile(`^type:\spipeline`)
 var searchaliasview = regexp.MustCompile(`^type:\ssearchaliasview`)
+var user = regexp.MustCompile(`^type:\suser`)
+var analyzer = regexp.MustCompile(`^type:\sanalyzer`)
 
 // User the data used to update a user account
 type User struct {
@@ -66,14 +79,50 @@ type Database struct {
 	Allowed    []User
 	Disallowed []string
 
+	// Defaults for the collections in a new database on a cluster.
+	Sharding          *string
+	ReplicationFactor *ReplicationFactor
+	WriteConcern      *int
+
 	cl driver.Client
 	db driver.Database
 }
 
+// UserAccount the YAML struct for managing a user and what they may access.
+// The migration's name is the username.
+type UserAccount struct {
+	Operation `yaml:",inline"`
+
+	// Password is the password itself or an extras key, such as ${ordersPassword}.
+	Password *string
+	Active   *bool
+	// Extra is arbitrary data Arango keeps with the user.
+	Extra map[string]interface{}
+	// Grants sets the user's access to databases and collections.
+	Grants []Permission
+	// Revokes removes access, so it falls back to the database's or the
+	// server's default.
+	Revokes []Permission
+
+	cl driver.Client
+}
+
+// Permission is access to a database, or to a collection within one.
+type Permission struct {
+	// Database defaults to the one being migrated.
+	Database   string
+	Collection string
+	// Grant is rw, ro or none. Revokes leave it out.
+	Grant string
+}
+
 // Collection the YAML struct for configuring a collection migration.
 type Collection struct {
 	Operation `yaml:",inline"`
 
+	// NewName is what a rename calls the collection.
+	NewName string `yaml:"newName,omitempty"`
+
 	ShardKeys        *[]string
 	JournalSize      *int
 	NumberOfShards   *int
@@ -83,6 +132,81 @@ type Collection struct {
 	Volatile         *bool
 	Compactable      *bool
 	CollectionType   string
+
+	// Increment and Offset tune the autoincrement key generator.
+	Increment *int
+	Offset    *int
+
+	// Cluster options. Only replicationfactor, writeconcern and cacheenabled
+	// can change after the collection is created.
+	ReplicationFactor    *ReplicationFactor
+	WriteConcern         *int
+	DistributeShardsLike *string
+	ShardingStrategy     *string
+	SmartJoinAttribute   *string
+	CacheEnabled         *bool
+
+	// Schema validates documents written to the collection.
+	Schema *Schema
+
+	// ComputedValues fill in document attributes from AQL as they're written.
+	ComputedValues []ComputedValue `yaml:"computedValues,omitempty"`
+}
+
+// ComputedValue is an attribute Arango computes with an AQL expression when
+// documents are written, such as a createdAt timestamp.
+type ComputedValue struct {
+	Name string `yaml:"name"`
+	// Expression is an AQL RETURN, with the document available as @doc.
+	Expression string `yaml:"expression"`
+	// ComputeOn lists the writes to compute on: insert, update and replace.
+	// Arango computes on all of them by default.
+	ComputeOn []string `yaml:"computeOn,omitempty"`
+	// Overwrite replaces any value the client sent for the attribute.
+	Overwrite bool `yaml:"overwrite"`
+	// FailOnWarning rejects the write when the expression warns.
+	FailOnWarning *bool `yaml:"failOnWarning,omitempty"`
+	// KeepNull stores the attribute even when the expression returns null.
+	KeepNull *bool `yaml:"keepNull,omitempty"`
+}
+
+// Schema the JSON Schema that documents in a collection must match.
+type Schema struct {
+	// Rule is the JSON Schema written inline.
+	Rule interface{}
+	// RuleFile is a .json file holding the rule, relative to the migration.
+	RuleFile string
+	// Level is when to validate: none, new, moderate or strict.
+	Level string
+	// Message is returned to the client when a document fails validation.
+	Message string
+}
+
+// ReplicationFactor is the number of copies kept of each shard, or satellite
+// to keep a copy on every DB server.
+type ReplicationFactor int
+
+// UnmarshalYAML accepts either a number or the word satellite.
+func (r *ReplicationFactor) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var n int
+	if err := unmarshal(&n); err == nil {
+		*r = ReplicationFactor(n)
+		return nil
+	}
+	var s string
+	if err := unmarshal(&s); err != nil {
+		return err
+	}
+	if s != "satellite" {
+		return fmt.Errorf("replicationfactor must be a number or satellite, not '%s'", s)
+	}
+	*r = ReplicationFactor(driver.ReplicationFactorSatellite)
+	return nil
+}
+
+// Satellite reports whether the collection is copied to every DB server.
+func (r ReplicationFactor) Satellite() bool {
+	return int(r) == driver.ReplicationFactorSatellite
 }
 
 // FullTextIndex defines how to build a full text index on a field
@@ -92,6 +216,7 @@ type FullTextIndex struct {
 	Collection   string
 	MinLength    int
 	InBackground bool
+	Existing     string `yaml:"existing,omitempty"`
 }
 
 // GeoIndex creates a GeoIndex within the specified collection.
@@ -101,6 +226,9 @@ type GeoIndex struct {
 	Collection   string
 	GeoJSON      bool
 	InBackground bool
+	Existing     string `yaml:"existing,omitempty"`
+	// LegacyPolygons keeps the pre 3.10 handling of GeoJSON polygons.
+	LegacyPolygons *bool `yaml:"legacyPolygons,omitempty"`
 }
 
 // HashIndex creates a hash index on the fields within the specified Collection.
@@ -112,6 +240,7 @@ type HashIndex struct {
 	Sparse        bool
 	NoDeduplicate bool
 	InBackground  bool
+	Existing      string `yaml:"existing,omitempty"`
 }
 
 // PersistentIndex creates a persistent index on the collections' fields.
@@ -122,7 +251,18 @@ type PersistentIndex struct {
 	Unique       bool
 	Sparse       bool
 	InBackground bool
+	Existing     string `yaml:"existing,omitempty"`
+	// StoredValues are extra attributes kept in the index so queries can
+	// read them without the document.
 	StoredValues []string
+	// Deduplicate, on by default, stops an array with repeated values from
+	// adding the document more than once.
+	Deduplicate *bool `yaml:"deduplicate,omitempty"`
+	// Estimates, on by default, keeps the selectivity estimates the query
+	// optimizer uses.
+	Estimates *bool `yaml:"estimates,omitempty"`
+	// CacheEnabled caches the index's lookups in memory.
+	CacheEnabled *bool `yaml:"cacheEnabled,omitempty"`
 }
 
 // TTLIndex creates a TTL index on the collections' fields.
@@ -132,6 +272,7 @@ type TTLIndex struct {
 	Collection   string
 	ExpireAfter  int
 	InBackground bool
+	Existing     string `yaml:"existing,omitempty"`
 }
 
 // SkiplistIndex creates a sliplist index on the collections' fields.
@@ -143,27 +284,168 @@ type SkiplistIndex struct {
 	Sparse        bool
 	NoDeduplicate bool
 	InBackground  bool
+	Existing      string `yaml:"existing,omitempty"`
 }
 
+// InvertedIndex the YAML struct for an inverted index, used by search-alias
+// views and SEARCH-like FILTERs.
 type InvertedIndex struct {
-	Operation    `yaml:",inline"`
-	Fields       []string
-	Collection   string
+	Operation  `yaml:",inline"`
+	Fields     []InvertedField
+	Collection string
+	// Analyzer is the default for fields that don't set their own.
 	Analyzer     string
 	InBackground bool
+	Existing     string `yaml:"existing,omitempty"`
+	Parallelism  *int
+	// Features, IncludeAllFields, TrackListPositions and SearchField are the
+	// defaults for fields that don't set their own.
+	Features           []driver.ArangoSearchAnalyzerFeature `yaml:"features,omitempty"`
+	IncludeAllFields   *bool                                `yaml:"includeAllFields,omitempty"`
+	TrackListPositions *bool                                `yaml:"trackListPositions,omitempty"`
+	SearchField        *bool                                `yaml:"searchField,omitempty"`
+	StoredValues       []StoredValue                        `yaml:"storedValues,omitempty"`
+	PrimarySort        *InvertedPrimarySort                 `yaml:"primarySort,omitempty"`
+	OptimizeTopK       []string                             `yaml:"optimizeTopK,omitempty"`
+	// Cache keeps the field normalization values in memory, PrimaryKeyCache
+	// the primary key column.
+	Cache           *bool `yaml:"cache,omitempty"`
+	PrimaryKeyCache *bool `yaml:"primaryKeyCache,omitempty"`
+
+	CleanupIntervalStep       *int64               `yaml:"cleanupIntervalStep,omitempty"`
+	CommitIntervalMsec        *int64               `yaml:"commitIntervalMsec,omitempty"`
+	ConsolidationIntervalMsec *int64               `yaml:"consolidationIntervalMsec,omitempty"`
+	ConsolidationPolicy       *ConsolidationPolicy `yaml:"consolidationPolicy,omitempty"`
+	WriteBufferIdle           *int64               `yaml:"writebufferIdle,omitempty"`
+	WriteBufferActive         *int64               `yaml:"writebufferActive,omitempty"`
+	WriteBufferSizeMax        *int64               `yaml:"writebufferSizeMax,omitempty"`
+
+	cl driver.Client
+}
+
+// InvertedField is one attribute of an inverted index. Settings left out fall
+// back to the index's own.
+type InvertedField struct {
+	Name               string
+	Analyzer           string                               `yaml:"analyzer,omitempty"`
+	Features           []driver.ArangoSearchAnalyzerFeature `yaml:"features,omitempty"`
+	IncludeAllFields   *bool                                `yaml:"includeAllFields,omitempty"`
+	TrackListPositions *bool                                `yaml:"trackListPositions,omitempty"`
+	SearchField        *bool                                `yaml:"searchField,omitempty"`
+	Cache              *bool                                `yaml:"cache,omitempty"`
+	// Nested indexes objects in an array so they can be matched one at a time.
+	Nested []InvertedField `yaml:"nested,omitempty"`
 }
 
+// UnmarshalYAML accepts either just the field's name or all its settings.
+func (f *InvertedField) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var name string
+	if err := unmarshal(&name); err == nil {
+		*f = InvertedField{Name: name}
+		return nil
+	}
+	type plain InvertedField
+	return unmarshal((*plain)(f))
+}
+
+// InvertedIndexFields converts the fields to the driver's.
+//
+// Deprecated: the driver's fields can't turn a setting off, or set a cache,
+// so the index is created from its own definition instead.
 func (i InvertedIndex) InvertedIndexFields() []driver.InvertedIndexField {
-	invertedIndexFields := []driver.InvertedIndexField{}
-	for _, field := range i.Fields {
-		invertedIndexFields = append(
-			invertedIndexFields,
-			driver.InvertedIndexField{Name: field},
-		)
+	if len(i.Fields) == 0 {
+		return []driver.InvertedIndexField{}
+	}
+	return driverInvertedFields(i.Fields)
+}
+
+func driverInvertedFields(fields []InvertedField) []driver.InvertedIndexField {
+	var invertedIndexFields []driver.InvertedIndexField
+	for _, f := range fields {
+		invertedIndexFields = append(invertedIndexFields, driver.InvertedIndexField{
+			Name:               f.Name,
+			Analyzer:           f.Analyzer,
+			Features:           f.Features,
+			IncludeAllFields:   f.IncludeAllFields != nil && *f.IncludeAllFields,
+			TrackListPositions: f.TrackListPositions != nil && *f.TrackListPositions,
+			SearchField:        f.SearchField != nil && *f.SearchField,
+			Nested:             driverInvertedFields(f.Nested),
+		})
 	}
 	return invertedIndexFields
 }
 
+// InvertedPrimarySort orders the index so matching sorts can be skipped.
+type InvertedPrimarySort struct {
+	Fields []SortField
+	// Compression is lz4, the default, or none.
+	Compression string `yaml:"compression,omitempty"`
+	Cache       *bool  `yaml:"cache,omitempty"`
+}
+
+// StoredValue is a group of attributes kept in the index for projections.
+type StoredValue struct {
+	Fields []string
+	// Compression is lz4, the default, or none.
+	Compression string `yaml:"compression,omitempty"`
+	Cache       *bool  `yaml:"cache,omitempty"`
+}
+
+// MDIIndex creates a multi-dimensional index for range queries over several
+// numeric fields at once.
+type MDIIndex struct {
+	Operation    `yaml:",inline"`
+	Fields       []string
+	Collection   string
+	Unique       bool
+	Sparse       bool
+	InBackground bool
+	Existing     string   `yaml:"existing,omitempty"`
+	StoredValues []string `yaml:"storedValues,omitempty"`
+}
+
+// MDIPrefixedIndex is an MDIIndex that first narrows the documents down by
+// equality on the prefixFields.
+type MDIPrefixedIndex struct {
+	MDIIndex     `yaml:",inline"`
+	PrefixFields []string `yaml:"prefixFields"`
+}
+
+// ZKDIndex is the experimental multi-dimensional index that came before
+// MDIIndex.
+type ZKDIndex struct {
+	Operation    `yaml:",inline"`
+	Fields       []string
+	Collection   string
+	Unique       bool
+	InBackground bool
+	Existing     string `yaml:"existing,omitempty"`
+}
+
+// VectorIndex creates an index for approximate nearest neighbor searches
+// over an embedding field.
+type VectorIndex struct {
+	Operation    `yaml:",inline"`
+	Field        string
+	Collection   string
+	InBackground bool
+	Existing     string `yaml:"existing,omitempty"`
+	Parallelism  *int
+	Sparse       *bool
+	StoredValues []string `yaml:"storedValues,omitempty"`
+	// Metric is cosine, l2 or innerProduct.
+	Metric string
+	// Dimension is the length of every vector.
+	Dimension int
+	// NLists is the number of clusters the vectors are split into.
+	NLists             int    `yaml:"nLists"`
+	DefaultNProbe      *int   `yaml:"defaultNProbe,omitempty"`
+	TrainingIterations *int   `yaml:"trainingIterations,omitempty"`
+	Factory            string `yaml:"factory,omitempty"`
+
+	cl driver.Client
+}
+
 // AQL allows arbitrary AQL execution as part of the migration.
 type AQL struct {
 	Operation `yaml:",inline"`
@@ -288,6 +570,22 @@ type PipelineAnalyzer struct {
 	Operation  `yaml:",inline"`
 	Properties driver.ArangoSearchAnalyzerProperties `yaml:"properties,omitempty"`
 	Features   []driver.ArangoSearchAnalyzerFeature  `yaml:"features,omitempty"`
+
+	cl driver.Client
+}
+
+// Analyzer the YAML struct for an ArangoSearch analyzer of any kind.
+type Analyzer struct {
+	Operation `yaml:",inline"`
+	// AnalyzerType is the kind of analyzer, such as text, ngram or pipeline.
+	AnalyzerType string                                `yaml:"analyzerType"`
+	Properties   driver.ArangoSearchAnalyzerProperties `yaml:"properties,omitempty"`
+	Features     []driver.ArangoSearchAnalyzerFeature  `yaml:"features,omitempty"`
+	// Scope is database, the default, to keep the analyzer in the migrated
+	// database, or system to share it with every database from _system.
+	Scope string `yaml:"scope,omitempty"`
+
+	cl driver.Client
 }
 
 var validVersion = regexp.MustCompile(`^\d*(\.\d*)*?$`)
@@ -333,7 +631,8 @@ func version(s string) string {
 
 // nearlyLexical sorts the paths based on near lexical sorting.
 // Chomps the description of the migration off. Uses just the
-// version information.
+// version information, falling back to the file name when two
+// versions are the same.
 func nearlyLexical(s []string) func(i, j int) bool {
 	return func(i, j int) bool {
 		curV := version(s[i])
@@ -369,7 +668,7 @@ func nearlyLexical(s []string) func(i, j int) bool {
 				return false
 			}
 		}
-		return false
+		return filepath.Base(s[i]) < filepath.Base(s[j])
 	}
 }
 
@@ -438,17 +737,58 @@ func pickT(contents []byte) (Migration, error) {
 		return new(SkiplistIndex), nil
 	case invertedidx.MatchString(s):
 		return new(InvertedIndex), nil
+	case mdiidx.MatchString(s):
+		return new(MDIIndex), nil
+	case mdiprefixedidx.MatchString(s):
+		return new(MDIPrefixedIndex), nil
+	case zkdidx.MatchString(s):
+		return new(ZKDIndex), nil
+	case vectoridx.MatchString(s):
+		return new(VectorIndex), nil
 	case view.MatchString(s):
 		return new(SearchView), nil
 	case pipeline.MatchString(s):
 		return new(PipelineAnalyzer), nil
 	case searchaliasview.MatchString(s):
 		return new(SearchAliasView), nil
+	case user.MatchString(s):
+		return new(UserAccount), nil
+	case analyzer.MatchString(s):
+		return new(Analyzer), nil
 	default:
 		return nil, errors.New("Can't determine YAML type '" + s + "'")
 	}
 }
 
+// referencer is implemented by migrations that pull in other files, such as
+// a JSON schema kept next to the migration.
+type referencer interface {
+	// references points at the fields holding file paths.
+	references() []*string
+}
+
+// withReferences resolves the referenced paths relative to the migration and
+// folds their contents into its checksum, so editing a referenced file
+// counts as editing the migration.
+func withReferences(childPath string, contents []byte, refs []*string) (string, error) {
+	h := md5.New()
+	h.Write(contents)
+	for _, ref := range refs {
+		if ref == nil || *ref == "" {
+			continue
+		}
+		if !filepath.IsAbs(*ref) {
+			*ref = filepath.Join(filepath.Dir(childPath), *ref)
+		}
+		bytes, _, err := open(*ref)
+		if err != nil {
+			return "", fmt.Errorf("couldn't read '%s' referenced by '%s': %w", *ref, childPath, err)
+		}
+		h.Write(bytes)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
 /*
 Converts a path to the proper underlying types specified in
 the childPath.
@@ -466,6 +806,13 @@ func toStruct(childPath string) (Migration, error) {
 		return nil, err
 	}
 
+	if r, ok := t.(referencer); ok {
+		checksum, err = withReferences(childPath, contents, r.references())
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	t.SetFileName(filepath.Base(childPath))
 	t.SetCheckSum(checksum)
 	return t, nil
@@ -66,3 +66,17 @@ func TestDecNumericVersions(t *testing.T) {
 		v,
 	)
 }
+
+func TestSameVersionByName(t *testing.T) {
+	v := []string{
+		"3_search.migration",
+		"3_search.analyzer.migration",
+		"2_collection.migration",
+	}
+	sort.Slice(v, nearlyLexical(v))
+	assert.Equal(
+		t,
+		[]string{"2_collection.migration", "3_search.analyzer.migration", "3_search.migration"},
+		v,
+	)
+}
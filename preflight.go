@@ -0,0 +1,180 @@
+package arangomigo
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"log"
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/arangodb/go-driver"
+	"github.com/pkg/errors"
+)
+
+const systemDb = "_system"
+
+// preflight makes sure the server is reachable and the user may do what the
+// migrations ask of it before anything runs. Catching a bad password or a
+// missing grant here beats failing halfway through the migration set.
+func preflight(ctx context.Context, c Config, cl driver.Client, pms []PairedMigrations) error {
+	v, err := cl.Version(ctx)
+	if driver.IsUnauthorized(err) {
+		return errors.Errorf(
+			"Arango rejected the credentials%s. Check the username and password, or the jwt",
+			forUser(c),
+		)
+	} else if e(err) {
+		return errors.Wrapf(
+			err,
+			"Couldn't reach Arango at %v. Check the endpoints and that the server is running",
+			c.Endpoints,
+		)
+	}
+	log.Printf("Connected to ArangoDB %s %s\n", v.Version, v.License)
+
+	exists, err := cl.DatabaseExists(ctx, c.Db)
+	if driver.IsUnauthorized(err) || driver.IsForbidden(err) {
+		return errors.Errorf(
+			"User%s has no access to database '%s'. Grant it rw access",
+			forUser(c), c.Db,
+		)
+	} else if e(err) {
+		return errors.Wrapf(err, "Couldn't check if database '%s' exists", c.Db)
+	}
+
+	creates := false
+	if len(pms) > 0 {
+		if d, ok := pms[0].change.(*Database); ok && d.Action == CREATE {
+			creates = true
+		}
+	}
+	if !exists && !creates {
+		return errors.Errorf(
+			"Database '%s' does not exist and the first migration doesn't create it",
+			c.Db,
+		)
+	}
+
+	user, err := preflightUser(c)
+	if e(err) {
+		return err
+	}
+	if user == "" {
+		log.Println("The jwt doesn't name a user, skipping the permission checks")
+		return nil
+	}
+
+	conn := cl.Connection()
+	if !exists {
+		grant, err := access(ctx, conn, user, systemDb)
+		if e(err) {
+			return errors.Wrapf(err, "Couldn't read the permissions of user '%s'", user)
+		}
+		if grant != driver.GrantReadWrite {
+			return errors.Errorf(
+				"User '%s' can't create database '%s'. Creating databases needs rw access to %s, not %s",
+				user, c.Db, systemDb, grant,
+			)
+		}
+		return nil
+	}
+
+	grant, err := access(ctx, conn, user, c.Db)
+	if e(err) {
+		return errors.Wrapf(err, "Couldn't read the permissions of user '%s'", user)
+	}
+	if grant != driver.GrantReadWrite {
+		return errors.Errorf(
+			"User '%s' has %s access to database '%s', but migrating needs rw",
+			user, grant, c.Db,
+		)
+	}
+
+	db, err := cl.Database(driver.WithSkipExistCheck(ctx, true), c.Db)
+	if e(err) {
+		return err
+	}
+	colExists, err := db.CollectionExists(ctx, migCol)
+	if e(err) {
+		return errors.Wrapf(err, "Couldn't check the '%s' collection in database '%s'", migCol, c.Db)
+	}
+	if colExists {
+		grant, err = access(ctx, conn, user, c.Db, migCol)
+		if e(err) {
+			return errors.Wrapf(err, "Couldn't read the permissions of user '%s'", user)
+		}
+		if grant != driver.GrantReadWrite {
+			return errors.Errorf(
+				"User '%s' has %s access to collection '%s' in database '%s', but the migration history needs rw",
+				user, grant, migCol, c.Db,
+			)
+		}
+	}
+	return nil
+}
+
+// forUser names the configured user in error messages, if there is one.
+func forUser(c Config) string {
+	if c.Username == "" {
+		return ""
+	}
+	return " for user '" + c.Username + "'"
+}
+
+// preflightUser finds the user whose permissions to check. A JWT issued to a
+// user carries its name; one signed with the server secret has none and is
+// a superuser.
+func preflightUser(c Config) (string, error) {
+	token, err := jwtToken(c)
+	if e(err) {
+		return "", err
+	}
+	if token == "" {
+		return c.Username, nil
+	}
+
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return "", errors.New("The jwt is not a valid token")
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if e(err) {
+		return "", errors.Wrap(err, "Couldn't decode the jwt")
+	}
+	claims := struct {
+		PreferredUsername string `json:"preferred_username"`
+	}{}
+	if err := json.Unmarshal(payload, &claims); e(err) {
+		return "", errors.Wrap(err, "Couldn't decode the jwt")
+	}
+	return claims.PreferredUsername, nil
+}
+
+// access looks up the effective grant the user has on a database, or on a
+// collection within it.
+func access(ctx context.Context, conn driver.Connection, user string, target ...string) (driver.Grant, error) {
+	parts := []string{"_api/user", url.PathEscape(user), "database"}
+	for _, t := range target {
+		parts = append(parts, url.PathEscape(t))
+	}
+	req, err := conn.NewRequest("GET", path.Join(parts...))
+	if e(err) {
+		return driver.GrantNone, err
+	}
+	resp, err := conn.Do(ctx, req)
+	if e(err) {
+		return driver.GrantNone, err
+	}
+	if err := resp.CheckStatus(200); e(err) {
+		return driver.GrantNone, err
+	}
+	data := struct {
+		Result driver.Grant `json:"result"`
+	}{}
+	if err := resp.ParseBody("", &data); e(err) {
+		return driver.GrantNone, err
+	}
+	return data.Result, nil
+}
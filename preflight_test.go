@@ -0,0 +1,144 @@
+package arangomigo
+
+import (
+	"context"
+	"encoding/base64"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Canned reply from the fake Arango server.
+type reply struct {
+	status int
+	body   string
+}
+
+// Serves canned replies keyed by method and path, like "GET /_api/version".
+// Anything else gets Arango's not found error.
+func fakeArango(t *testing.T, routes map[string]reply) *httptest.Server {
+	return httptest.NewServer(arangoHandler(routes))
+}
+
+func arangoHandler(routes map[string]reply) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		rep, ok := routes[r.Method+" "+r.URL.Path]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"error":true,"code":404,"errorNum":1228,"errorMessage":"not found"}`))
+			return
+		}
+		w.WriteHeader(rep.status)
+		w.Write([]byte(rep.body))
+	}
+}
+
+// Like fakeArango, but also keeps each request that changes something as
+// "METHOD /path body".
+func recordingArango(t *testing.T, routes map[string]reply) (*httptest.Server, *[]string) {
+	var changes []string
+	handler := arangoHandler(routes)
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			body, _ := ioutil.ReadAll(r.Body)
+			changes = append(changes, strings.TrimSpace(r.Method+" "+r.URL.Path+" "+string(body)))
+		}
+		handler(w, r)
+	})), &changes
+}
+
+func okReply(body string) reply {
+	return reply{status: http.StatusOK, body: body}
+}
+
+// The routes of a healthy server where patrick may migrate MigoPreflight.
+func preflightRoutes() map[string]reply {
+	return map[string]reply{
+		"GET /_api/version":                                        okReply(`{"server":"arango","version":"3.11.0","license":"community"}`),
+		"GET /_db/MigoPreflight/_api/database/current":             okReply(`{"result":{"name":"MigoPreflight"}}`),
+		"GET /_api/user/patrick/database/MigoPreflight":            okReply(`{"result":"rw"}`),
+		"GET /_db/MigoPreflight/_api/collection/arangomigo":        okReply(`{"name":"arangomigo"}`),
+		"GET /_api/user/patrick/database/MigoPreflight/arangomigo": okReply(`{"result":"rw"}`),
+		"GET /_api/user/patrick/database/_system":                  okReply(`{"result":"rw"}`),
+	}
+}
+
+func preflightWith(t *testing.T, routes map[string]reply, c Config, pms []PairedMigrations) error {
+	ts := fakeArango(t, routes)
+	defer ts.Close()
+
+	c.Endpoints = []string{ts.URL}
+	c.Db = "MigoPreflight"
+	cl, err := client(c)
+	if e(err) {
+		t.Fatal(err)
+	}
+	return preflight(context.Background(), c, cl, pms)
+}
+
+func createsDb() []PairedMigrations {
+	return []PairedMigrations{{change: &Database{Operation: Operation{Action: CREATE, Name: "MigoPreflight"}}}}
+}
+
+func TestPreflightPasses(t *testing.T) {
+	err := preflightWith(t, preflightRoutes(), Config{Username: "patrick"}, nil)
+	assert.NoError(t, err)
+}
+
+func TestPreflightUnreachable(t *testing.T) {
+	c := Config{Endpoints: []string{"http://127.0.0.1:1"}, Db: "MigoPreflight"}
+	cl, err := client(c)
+	assert.NoError(t, err)
+	err = preflight(context.Background(), c, cl, nil)
+	assert.Contains(t, err.Error(), "Couldn't reach Arango at [http://127.0.0.1:1]")
+}
+
+func TestPreflightBadCredentials(t *testing.T) {
+	routes := preflightRoutes()
+	routes["GET /_api/version"] = reply{http.StatusUnauthorized, `{"error":true,"code":401,"errorNum":11,"errorMessage":"not authorized"}`}
+	err := preflightWith(t, routes, Config{Username: "patrick"}, nil)
+	assert.EqualError(t, err, "Arango rejected the credentials for user 'patrick'. Check the username and password, or the jwt")
+}
+
+func TestPreflightMissingDatabase(t *testing.T) {
+	routes := preflightRoutes()
+	delete(routes, "GET /_db/MigoPreflight/_api/database/current")
+	err := preflightWith(t, routes, Config{Username: "patrick"}, nil)
+	assert.EqualError(t, err, "Database 'MigoPreflight' does not exist and the first migration doesn't create it")
+
+	err = preflightWith(t, routes, Config{Username: "patrick"}, createsDb())
+	assert.NoError(t, err, "Should be able to create the database")
+
+	routes["GET /_api/user/patrick/database/_system"] = okReply(`{"result":"ro"}`)
+	err = preflightWith(t, routes, Config{Username: "patrick"}, createsDb())
+	assert.EqualError(t, err, "User 'patrick' can't create database 'MigoPreflight'. Creating databases needs rw access to _system, not ro")
+}
+
+func TestPreflightReadOnly(t *testing.T) {
+	routes := preflightRoutes()
+	routes["GET /_api/user/patrick/database/MigoPreflight"] = okReply(`{"result":"ro"}`)
+	err := preflightWith(t, routes, Config{Username: "patrick"}, nil)
+	assert.EqualError(t, err, "User 'patrick' has ro access to database 'MigoPreflight', but migrating needs rw")
+
+	routes = preflightRoutes()
+	routes["GET /_api/user/patrick/database/MigoPreflight/arangomigo"] = okReply(`{"result":"none"}`)
+	err = preflightWith(t, routes, Config{Username: "patrick"}, nil)
+	assert.EqualError(t, err, "User 'patrick' has none access to collection 'arangomigo' in database 'MigoPreflight', but the migration history needs rw")
+}
+
+func TestPreflightUserFromJWT(t *testing.T) {
+	claims := base64.RawURLEncoding.EncodeToString([]byte(`{"preferred_username":"patrick"}`))
+	user, err := preflightUser(Config{Username: "ignored", JWT: "header." + claims + ".signature"})
+	assert.NoError(t, err)
+	assert.Equal(t, "patrick", user)
+
+	superuser := base64.RawURLEncoding.EncodeToString([]byte(`{"server_id":"migo"}`))
+	user, err = preflightUser(Config{JWT: "header." + superuser + ".signature"})
+	assert.NoError(t, err)
+	assert.Equal(t, "", user, "Server signed tokens have no user")
+}
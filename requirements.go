@@ -0,0 +1,282 @@
+package arangomigo
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+
+	"github.com/arangodb/go-driver"
+	"github.com/pkg/errors"
+)
+
+// Requirements describes the server a migration needs to run.
+type Requirements struct {
+	// Server is a version constraint such as ">=3.10" or ">=3.10, <4".
+	Server string
+	// Edition is either community or enterprise.
+	Edition string
+	// Deployment is either single or cluster.
+	Deployment string
+}
+
+// Enumerated values for the Requirements.Edition
+const (
+	Community  = "community"
+	Enterprise = "enterprise"
+)
+
+// Enumerated values for the Requirements.Deployment
+const (
+	SingleServer = "single"
+	Cluster      = "cluster"
+)
+
+// Requirements gets what the migration's author asked of the server.
+func (op *Operation) Requirements() Requirements {
+	if op.Requires == nil {
+		return Requirements{}
+	}
+	return *op.Requires
+}
+
+// defaultRequirements are the built in needs of each migration type. They
+// apply on top of anything set with requires.
+func defaultRequirements(m Migration) []Requirements {
+	switch t := m.(type) {
+	case *InvertedIndex:
+		reqs := []Requirements{{Server: ">=3.10"}}
+		if t.nested() {
+			reqs = append(reqs, Requirements{Edition: Enterprise})
+		}
+		if t.cached() {
+			reqs = append(reqs, Requirements{Server: ">=3.10.2", Edition: Enterprise})
+		}
+		if len(t.OptimizeTopK) > 0 {
+			reqs = append(reqs, Requirements{Server: ">=3.11", Edition: Enterprise})
+		}
+		return reqs
+	case *PersistentIndex:
+		var reqs []Requirements
+		if t.Estimates != nil {
+			reqs = append(reqs, Requirements{Server: ">=3.8"})
+		}
+		if len(t.StoredValues) > 0 || t.CacheEnabled != nil {
+			reqs = append(reqs, Requirements{Server: ">=3.10"})
+		}
+		return reqs
+	case *GeoIndex:
+		if t.LegacyPolygons != nil {
+			return []Requirements{{Server: ">=3.10"}}
+		}
+	case *ZKDIndex:
+		return []Requirements{{Server: ">=3.9"}}
+	case *MDIIndex, *MDIPrefixedIndex:
+		return []Requirements{{Server: ">=3.12"}}
+	case *VectorIndex:
+		reqs := []Requirements{{Server: ">=3.12.4"}}
+		if t.Metric == InnerProductMetric {
+			reqs = append(reqs, Requirements{Server: ">=3.12.5"})
+		}
+		if len(t.StoredValues) > 0 {
+			reqs = append(reqs, Requirements{Server: ">=3.12.7"})
+		}
+		return reqs
+	case *SearchAliasView:
+		return []Requirements{{Server: ">=3.10"}}
+	case *Analyzer:
+		var reqs []Requirements
+		if since, ok := analyzerSince[t.AnalyzerType]; ok {
+			reqs = append(reqs, Requirements{Server: since})
+		}
+		if enterpriseAnalyzers[t.AnalyzerType] {
+			reqs = append(reqs, Requirements{Edition: Enterprise})
+		}
+		return reqs
+	case *Graph:
+		if t.Smart != nil && *t.Smart {
+			return []Requirements{{Edition: Enterprise}}
+		}
+	case *Collection:
+		var reqs []Requirements
+		if t.SmartJoinAttribute != nil ||
+			(t.ShardingStrategy != nil && strings.HasPrefix(*t.ShardingStrategy, "enterprise")) {
+			reqs = append(reqs, Requirements{Edition: Enterprise})
+		}
+		if len(t.ComputedValues) > 0 {
+			reqs = append(reqs, Requirements{Server: ">=3.10"})
+		}
+		if t.autoincrement() {
+			reqs = append(reqs, Requirements{Deployment: SingleServer})
+		}
+		return reqs
+	}
+	return nil
+}
+
+// requirementsOf merges the built in and the declared requirements.
+func requirementsOf(m Migration) []Requirements {
+	reqs := defaultRequirements(m)
+	if r, ok := m.(interface{ Requirements() Requirements }); ok {
+		if declared := r.Requirements(); declared != (Requirements{}) {
+			reqs = append(reqs, declared)
+		}
+	}
+	return reqs
+}
+
+// checkRequirements compares every migration's requirements with the server
+// before any of them run, listing each one the server can't satisfy.
+func checkRequirements(ctx context.Context, cl driver.Client, pms []PairedMigrations) error {
+	v, err := cl.Version(ctx)
+	if e(err) {
+		return errors.Wrap(err, "Couldn't read the server version")
+	}
+	edition := v.License
+	if edition == "" {
+		edition = Community
+	}
+
+	// Only ask for the role when a migration needs a particular deployment.
+	deployment := ""
+	for _, pm := range pms {
+		for _, r := range requirementsOf(pm.change) {
+			if r.Deployment != "" && deployment == "" {
+				role, err := cl.ServerRole(ctx)
+				if e(err) {
+					return errors.Wrap(err, "Couldn't read the server role")
+				}
+				deployment = SingleServer
+				if role == driver.ServerRoleCoordinator {
+					deployment = Cluster
+				}
+			}
+		}
+	}
+
+	var problems []string
+	for _, pm := range pms {
+		for _, r := range requirementsOf(pm.change) {
+			if problem := r.unmetBy(string(v.Version), edition, deployment); problem != "" {
+				problems = append(problems, fmt.Sprintf("%s %s", pm.change.FileName(), problem))
+			}
+		}
+	}
+	if len(problems) > 0 {
+		return errors.Errorf(
+			"The server is ArangoDB %s %s, but some migrations need more:\n\t%s",
+			v.Version, edition, strings.Join(problems, "\n\t"),
+		)
+	}
+	log.Printf("ArangoDB %s %s meets the migrations' requirements\n", v.Version, edition)
+	for _, warning := range deprecations(string(v.Version), pms) {
+		log.Printf("Warning: %s\n", warning)
+	}
+	return nil
+}
+
+// unmetBy explains why the server doesn't meet the requirements. Returns an
+// empty string when it does.
+func (r Requirements) unmetBy(version, edition, deployment string) string {
+	if r.Edition != "" {
+		want := strings.ToLower(r.Edition)
+		if want != Community && want != Enterprise {
+			return fmt.Sprintf("requires unknown edition '%s', use community or enterprise", r.Edition)
+		}
+		if want == Enterprise && edition != Enterprise {
+			return "requires the enterprise edition"
+		}
+	}
+	if r.Deployment != "" {
+		want := strings.ToLower(r.Deployment)
+		if want != SingleServer && want != Cluster {
+			return fmt.Sprintf("requires unknown deployment '%s', use single or cluster", r.Deployment)
+		}
+		if want != deployment {
+			return fmt.Sprintf("requires a %s deployment", want)
+		}
+	}
+	if r.Server != "" {
+		met, err := satisfies(version, r.Server)
+		if e(err) {
+			return err.Error()
+		}
+		if !met {
+			return fmt.Sprintf("requires server %s", r.Server)
+		}
+	}
+	return ""
+}
+
+var versionOps = []string{">=", "<=", "==", "!=", ">", "<", "="}
+
+// satisfies checks the version against a comma separated list of
+// constraints, all of which must hold.
+func satisfies(version, constraints string) (bool, error) {
+	for _, c := range strings.Split(constraints, ",") {
+		c = strings.TrimSpace(c)
+		op := "="
+		for _, o := range versionOps {
+			if strings.HasPrefix(c, o) {
+				op = o
+				c = strings.TrimSpace(c[len(o):])
+				break
+			}
+		}
+		if !validVersion.MatchString(c) || c == "" {
+			return false, errors.Errorf("has an invalid server constraint '%s'", constraints)
+		}
+
+		cmp := compareVersions(version, c)
+		var met bool
+		switch op {
+		case ">=":
+			met = cmp >= 0
+		case "<=":
+			met = cmp <= 0
+		case ">":
+			met = cmp > 0
+		case "<":
+			met = cmp < 0
+		case "!=":
+			met = cmp != 0
+		default:
+			met = cmp == 0
+		}
+		if !met {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// compareVersions compares dotted versions numerically, treating missing
+// parts as 0 and ignoring suffixes such as "-devel".
+func compareVersions(a, b string) int {
+	as := strings.Split(a, ".")
+	bs := strings.Split(b, ".")
+	for i := 0; i < len(as) || i < len(bs); i++ {
+		x, y := versionPart(as, i), versionPart(bs, i)
+		if x < y {
+			return -1
+		}
+		if x > y {
+			return 1
+		}
+	}
+	return 0
+}
+
+func versionPart(parts []string, i int) int {
+	if i >= len(parts) {
+		return 0
+	}
+	p := parts[i]
+	end := 0
+	for end < len(p) && p[end] >= '0' && p[end] <= '9' {
+		end++
+	}
+	n, _ := strconv.Atoi(p[:end])
+	return n
+}
@@ -0,0 +1,124 @@
+package arangomigo
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v2"
+)
+
+func TestSatisfies(t *testing.T) {
+	cases := []struct {
+		version    string
+		constraint string
+		met        bool
+	}{
+		{"3.10.0", ">=3.10", true},
+		{"3.9.12", ">=3.10", false},
+		{"3.12.0-devel", ">=3.12", true},
+		{"3.11.4", ">=3.10, <3.12", true},
+		{"3.12.1", ">=3.10, <3.12", false},
+		{"3.10.2", "3.10.2", true},
+		{"3.10.2", "!=3.10.2", false},
+		{"4.0.0", ">3.12.4", true},
+	}
+	for _, c := range cases {
+		met, err := satisfies(c.version, c.constraint)
+		assert.NoError(t, err)
+		assert.Equal(t, c.met, met, "%s %s", c.version, c.constraint)
+	}
+
+	_, err := satisfies("3.10.0", ">=three")
+	assert.EqualError(t, err, "has an invalid server constraint '>=three'")
+}
+
+func TestRequiresParses(t *testing.T) {
+	contents := []byte(`type: collection
+action: create
+name: recipes
+requires:
+  server: ">=3.11"
+  edition: enterprise
+`)
+	m, err := pickT(contents)
+	assert.NoError(t, err)
+	assert.NoError(t, yaml.UnmarshalStrict(contents, m))
+	assert.Equal(t, []Requirements{{Server: ">=3.11", Edition: Enterprise}}, requirementsOf(m))
+}
+
+func TestCheckRequirements(t *testing.T) {
+	ts := fakeArango(t, map[string]reply{
+		"GET /_api/version": okReply(`{"server":"arango","version":"3.9.1","license":"community"}`),
+	})
+	defer ts.Close()
+	cl, err := client(Config{Endpoints: []string{ts.URL}})
+	assert.NoError(t, err)
+
+	smart := true
+	index := &InvertedIndex{Operation: Operation{fileName: "1.migration"}}
+	graph := &Graph{Operation: Operation{fileName: "2.migration"}, Smart: &smart}
+	col := &Collection{Operation: Operation{fileName: "3.migration", Requires: &Requirements{Server: ">=3.0"}}}
+
+	err = checkRequirements(context.Background(), cl, []PairedMigrations{{change: col}})
+	assert.NoError(t, err)
+
+	err = checkRequirements(context.Background(), cl, []PairedMigrations{{change: index}, {change: graph}, {change: col}})
+	assert.EqualError(
+		t,
+		err,
+		"The server is ArangoDB 3.9.1 community, but some migrations need more:\n"+
+			"\t1.migration requires server >=3.10\n"+
+			"\t2.migration requires the enterprise edition",
+	)
+}
+
+func TestCheckDeployment(t *testing.T) {
+	ts := fakeArango(t, map[string]reply{
+		"GET /_api/version":       okReply(`{"server":"arango","version":"3.11.0","license":"community"}`),
+		"GET /_admin/server/role": okReply(`{"role":"COORDINATOR","mode":"default"}`),
+	})
+	defer ts.Close()
+	cl, err := client(Config{Endpoints: []string{ts.URL}})
+	assert.NoError(t, err)
+
+	autoincrement := "autoincrement"
+	col := &Collection{Operation: Operation{fileName: "1.migration", Action: CREATE}, KeyGeneratorType: &autoincrement}
+	spread := &Collection{Operation: Operation{fileName: "2.migration", Requires: &Requirements{Deployment: Cluster}}}
+
+	err = checkRequirements(context.Background(), cl, []PairedMigrations{{change: col}, {change: spread}})
+	assert.EqualError(
+		t,
+		err,
+		"The server is ArangoDB 3.11.0 community, but some migrations need more:\n"+
+			"\t1.migration requires a single deployment",
+	)
+}
+
+func TestRequirementsOnlyForPending(t *testing.T) {
+	ts := fakeArango(t, map[string]reply{
+		"GET /_api/version":                                   okReply(`{"server":"arango","version":"3.9.1","license":"community"}`),
+		"GET /_db/Shop/_api/database/current":                 okReply(`{"result":{"name":"Shop"}}`),
+		"GET /_db/Shop/_api/collection/arangomigo":            okReply(`{"name":"arangomigo"}`),
+		"HEAD /_db/Shop/_api/document/arangomigo/1.migration": okReply(``),
+	})
+	defer ts.Close()
+	cl, err := client(Config{Endpoints: []string{ts.URL}})
+	assert.NoError(t, err)
+
+	// An inverted index that ran before the server was downgraded, say.
+	index := &InvertedIndex{Operation: Operation{fileName: "1.migration"}}
+	col := &Collection{Operation: Operation{fileName: "2.migration", Requires: &Requirements{Server: ">=3.0"}}}
+	pms := []PairedMigrations{{change: index}, {change: col}}
+
+	ran, err := applied(context.Background(), cl, "Shop", pms)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]bool{"1.migration": true, "2.migration": false}, ran)
+	assert.Equal(t, []PairedMigrations{{change: col}}, pending(pms, ran))
+	assert.NoError(t, checkRequirements(context.Background(), cl, pending(pms, ran)))
+
+	// Nothing has run in a database that isn't there yet.
+	ran, err = applied(context.Background(), cl, "Missing", pms)
+	assert.NoError(t, err)
+	assert.Len(t, pending(pms, ran), 2)
+}
@@ -0,0 +1,285 @@
+package arangomigo
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/arangodb/go-driver"
+	"github.com/pkg/errors"
+)
+
+// multiTenant reports whether the config migrates many databases instead of
+// the one in Db.
+func (c Config) multiTenant() bool {
+	return len(c.Databases) > 0 || c.DatabasePattern != "" || c.DatabaseQuery != ""
+}
+
+// checkTenants makes sure the config names its databases one way only, and
+// that the migrations, when there are any yet, can run in each of them.
+func checkTenants(c Config, pms []PairedMigrations) error {
+	sources := 0
+	for _, set := range []bool{len(c.Databases) > 0, c.DatabasePattern != "", c.DatabaseQuery != ""} {
+		if set {
+			sources++
+		}
+	}
+	if sources > 1 {
+		return errors.New("Please specify only one of databases, database_pattern or database_query in the config")
+	}
+	if sources == 1 && c.Db != "" {
+		return errors.New("Please specify either db or the tenant databases in the config, not both")
+	}
+	if c.DatabasePattern != "" {
+		if _, err := regexp.Compile(c.DatabasePattern); e(err) {
+			return errors.Wrapf(err, "The database_pattern '%s' isn't a valid regular expression", c.DatabasePattern)
+		}
+	}
+	if sources == 0 || len(pms) == 0 {
+		return nil
+	}
+	if d, ok := pms[0].change.(*Database); ok && d.Action == CREATE {
+		return errors.Errorf(
+			"%s creates database '%s', but the tenant databases have to exist already. "+
+				"Create them before migrating, or leave out the database creation",
+			pms[0].change.FileName(), d.Name,
+		)
+	}
+	for _, pm := range pms {
+		u, ok := pm.change.(*UserAccount)
+		if !ok {
+			continue
+		}
+		for _, p := range append(append([]Permission{}, u.Grants...), u.Revokes...) {
+			if p.Database == "" {
+				return errors.Errorf(
+					"%s changes user '%s' access without naming the database. Users are shared by the "+
+						"tenant databases, so each grant and revoke needs a database",
+					pm.change.FileName(), u.Name,
+				)
+			}
+		}
+	}
+	return nil
+}
+
+// sharedStep reports whether a migration belongs to no one tenant. Users live
+// on the server, and a step naming its database keeps its history there.
+func sharedStep(m Migration) bool {
+	if _, ok := m.(*UserAccount); ok {
+		return true
+	}
+	t, ok := m.(targeted)
+	return ok && t.TargetDatabase() != ""
+}
+
+// splitShared separates the steps to run once from those each tenant runs.
+func splitShared(pms []PairedMigrations) (shared, own []PairedMigrations) {
+	for _, pm := range pms {
+		if sharedStep(pm.change) {
+			shared = append(shared, pm)
+		} else {
+			own = append(own, pm)
+		}
+	}
+	return shared, own
+}
+
+// tenants finds the databases to migrate. A pattern must match the whole name.
+func tenants(ctx context.Context, c Config, cl driver.Client) ([]string, error) {
+	var names []string
+	switch {
+	case len(c.Databases) > 0:
+		names = append(names, c.Databases...)
+	case c.DatabasePattern != "":
+		pattern, err := regexp.Compile("^(?:" + c.DatabasePattern + ")$")
+		if e(err) {
+			return nil, errors.Wrapf(err, "The database_pattern '%s' isn't a valid regular expression", c.DatabasePattern)
+		}
+		dbs, err := cl.AccessibleDatabases(ctx)
+		if e(err) {
+			return nil, errors.Wrap(err, "Couldn't list the databases")
+		}
+		for _, db := range dbs {
+			if db.Name() != systemDb && pattern.MatchString(db.Name()) {
+				names = append(names, db.Name())
+			}
+		}
+		sort.Strings(names)
+	case c.DatabaseQuery != "":
+		sys, err := cl.Database(ctx, systemDb)
+		if e(err) {
+			return nil, errors.Wrapf(err, "Couldn't open %s to find the tenant databases", systemDb)
+		}
+		cur, err := sys.Query(ctx, c.DatabaseQuery, nil)
+		if e(err) {
+			return nil, errors.Wrapf(err, "Couldn't execute query '%s'", c.DatabaseQuery)
+		}
+		defer cur.Close()
+		for cur.HasMore() {
+			var name string
+			if _, err := cur.ReadDocument(ctx, &name); e(err) {
+				return nil, errors.Wrapf(err, "The database_query must return the databases' names")
+			}
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		return nil, errors.New("Found no tenant databases to migrate")
+	}
+	return names, nil
+}
+
+// appliedEverywhere finds the migrations every tenant database has already
+// run.
+func appliedEverywhere(ctx context.Context, c Config, cl driver.Client, pms []PairedMigrations) (map[string]bool, error) {
+	names, err := tenants(ctx, c, cl)
+	if e(err) {
+		return nil, err
+	}
+	return appliedIn(ctx, cl, names, pms)
+}
+
+// appliedIn finds the migrations all the databases have run, so a file is
+// only left out of the checks once no tenant still needs it. Shared steps
+// are looked up once, users in the history in _system.
+func appliedIn(ctx context.Context, cl driver.Client, names []string, pms []PairedMigrations) (map[string]bool, error) {
+	shared, own := splitShared(pms)
+	everywhere, err := applied(ctx, cl, systemDb, shared)
+	if e(err) {
+		return nil, err
+	}
+	for i, name := range names {
+		ran, err := applied(ctx, cl, name, own)
+		if e(err) {
+			return nil, err
+		}
+		for _, pm := range own {
+			file := pm.change.FileName()
+			everywhere[file] = ran[file] && (i == 0 || everywhere[file])
+		}
+	}
+	return everywhere, nil
+}
+
+// tenantResult is how migrating one tenant database went.
+type tenantResult struct {
+	name string
+	ran  int
+	err  error
+}
+
+// performTenants applies the migrations to every tenant database, a few at a
+// time, each keeping its own history. The steps they share run first, once.
+func performTenants(ctx context.Context, c Config, cl driver.Client, pms []PairedMigrations) error {
+	if err := checkTenants(c, pms); e(err) {
+		return err
+	}
+	names, err := tenants(ctx, c, cl)
+	if e(err) {
+		return err
+	}
+	ran, err := appliedIn(ctx, cl, names, pms)
+	if e(err) {
+		return err
+	}
+	if err := validate(pms, ran); e(err) {
+		return err
+	}
+	if err := checkRequirements(ctx, cl, pending(pms, ran)); e(err) {
+		return err
+	}
+
+	concurrency := c.Concurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	shared, own := splitShared(pms)
+	if err := migrateShared(ctx, c, cl, shared); e(err) {
+		return errors.Wrap(err, "Couldn't run the migrations the tenant databases share, so none were migrated")
+	}
+	log.Printf("Migrating %d tenant databases, %d at a time\n", len(names), concurrency)
+
+	results := make([]tenantResult, len(names))
+	slots := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	for i, name := range names {
+		wg.Add(1)
+		go func(i int, name string) {
+			defer wg.Done()
+			slots <- struct{}{}
+			defer func() { <-slots }()
+			results[i] = migrateTenant(ctx, c, cl, name, own)
+		}(i, name)
+	}
+	wg.Wait()
+	return summarize(results)
+}
+
+// migrateShared runs the steps no one tenant owns, one at a time, before the
+// tenants start, so they can't race each other to apply them. Users are
+// recorded in the history in _system.
+func migrateShared(ctx context.Context, c Config, cl driver.Client, shared []PairedMigrations) error {
+	if len(shared) == 0 {
+		return nil
+	}
+	sys, err := cl.Database(ctx, systemDb)
+	if e(err) {
+		return errors.Wrapf(err, "Couldn't open %s", systemDb)
+	}
+	if err := ensureHistory(ctx, sys); e(err) {
+		return err
+	}
+	_, err = migrateNow(ctx, c, cl, sys, shared)
+	return err
+}
+
+func migrateTenant(ctx context.Context, c Config, cl driver.Client, name string, pms []PairedMigrations) tenantResult {
+	c.Db = name
+	if err := preflight(ctx, c, cl, pms); e(err) {
+		return tenantResult{name: name, err: err}
+	}
+	db, err := loadDb(ctx, c, cl, &pms, c.Extras)
+	if e(err) {
+		return tenantResult{name: name, err: err}
+	}
+	ran, err := migrateNow(ctx, c, cl, db, pms)
+	return tenantResult{name: name, ran: ran, err: err}
+}
+
+// summarize reports which tenant databases migrated, failed or were already
+// up to date, failing if any of them failed.
+func summarize(results []tenantResult) error {
+	var succeeded, failed, skipped []string
+	for _, r := range results {
+		switch {
+		case e(r.err):
+			failed = append(failed, fmt.Sprintf("%s: %s", r.name, r.err))
+		case r.ran == 0:
+			skipped = append(skipped, r.name)
+		default:
+			succeeded = append(succeeded, r.name)
+		}
+	}
+	log.Printf(
+		"Tenant databases: %d succeeded, %d failed, %d skipped as up to date\n",
+		len(succeeded), len(failed), len(skipped),
+	)
+	if len(succeeded) > 0 {
+		log.Printf("Succeeded: %s\n", strings.Join(succeeded, ", "))
+	}
+	if len(skipped) > 0 {
+		log.Printf("Skipped: %s\n", strings.Join(skipped, ", "))
+	}
+	if len(failed) > 0 {
+		return errors.Errorf(
+			"%d of %d tenant databases failed to migrate:\n\t%s",
+			len(failed), len(results), strings.Join(failed, "\n\t"),
+		)
+	}
+	return nil
+}
@@ -0,0 +1,148 @@
+package arangomigo
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// A tenant whose database and history exist, with the given migration
+// already run or not.
+func tenantRoutes(routes map[string]reply, name string, ran bool) {
+	routes["GET /_db/"+name+"/_api/database/current"] = okReply(`{"result":{"name":"` + name + `"}}`)
+	routes["GET /_db/"+name+"/_api/collection/arangomigo"] = okReply(`{"name":"arangomigo"}`)
+	routes["POST /_db/"+name+"/_api/cursor"] = reply{status: http.StatusCreated, body: `{"result":[],"hasMore":false}`}
+	routes["POST /_db/"+name+"/_api/document/arangomigo"] = reply{status: http.StatusAccepted, body: `{"_key":"1_step.migration"}`}
+	if ran {
+		routes["HEAD /_db/"+name+"/_api/document/arangomigo/1_step.migration"] = okReply(``)
+	}
+}
+
+func TestTenantsFromPattern(t *testing.T) {
+	ts := fakeArango(t, map[string]reply{
+		"GET /_db/_system/_api/database/user": okReply(`{"result":["_system","tenant_b","tenant_a","tenant_a_archive","other"]}`),
+	})
+	defer ts.Close()
+	cl, err := client(Config{Endpoints: []string{ts.URL}})
+	assert.NoError(t, err)
+
+	names, err := tenants(context.Background(), Config{DatabasePattern: `tenant_[a-z]`}, cl)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"tenant_a", "tenant_b"}, names, "The pattern must match the whole name")
+
+	_, err = tenants(context.Background(), Config{DatabasePattern: `nobody`}, cl)
+	assert.EqualError(t, err, "Found no tenant databases to migrate")
+}
+
+func TestTenantsFromQuery(t *testing.T) {
+	ts, changes := recordingArango(t, map[string]reply{
+		"GET /_db/_system/_api/database/current": okReply(`{"result":{"name":"_system"}}`),
+		"POST /_db/_system/_api/cursor":          {status: http.StatusCreated, body: `{"result":["acme","globex"],"hasMore":false}`},
+	})
+	defer ts.Close()
+	cl, err := client(Config{Endpoints: []string{ts.URL}})
+	assert.NoError(t, err)
+
+	query := "FOR t IN tenants FILTER t.active RETURN t.db"
+	names, err := tenants(context.Background(), Config{DatabaseQuery: query}, cl)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"acme", "globex"}, names)
+	assert.Contains(t, (*changes)[0], query)
+}
+
+func TestPerformTenants(t *testing.T) {
+	routes := map[string]reply{
+		"GET /_api/version": okReply(`{"server":"arango","version":"3.11.0","license":"community"}`),
+	}
+	tenantRoutes(routes, "acme", false)
+	tenantRoutes(routes, "globex", true)
+	tenantRoutes(routes, "initech", false)
+	routes["POST /_db/initech/_api/cursor"] = reply{
+		status: http.StatusBadRequest,
+		body:   `{"error":true,"code":400,"errorNum":1203,"errorMessage":"collection or view not found: orders"}`,
+	}
+	ts := fakeArango(t, routes)
+	defer ts.Close()
+
+	c := Config{Endpoints: []string{ts.URL}, Databases: []string{"acme", "globex", "initech", "missing"}, Concurrency: 2}
+	err := perform(context.Background(), c, migrationsOf(t, "type: aql\nname: backfill\nquery: FOR o IN orders RETURN o\n"))
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "2 of 4 tenant databases failed to migrate:\n\tinitech: Couldn't execute query")
+	assert.Contains(t, err.Error(), "\n\tmissing: Database 'missing' does not exist")
+	assert.NotContains(t, err.Error(), "acme")
+	assert.NotContains(t, err.Error(), "globex")
+}
+
+func TestPerformTenantsSharedStepsOnce(t *testing.T) {
+	routes := map[string]reply{
+		"GET /_api/version":                           okReply(`{"server":"arango","version":"3.11.0","license":"community"}`),
+		"GET /_db/_system/_api/database/current":      okReply(`{"result":{"name":"_system"}}`),
+		"GET /_db/_system/_api/collection/arangomigo": okReply(`{"name":"arangomigo"}`),
+		"POST /_db/_system/_api/document/arangomigo":  {status: http.StatusAccepted, body: `{"_key":"1_step.migration"}`},
+		"POST /_api/user":                             {status: http.StatusCreated, body: `{"user":"reporter","active":true}`},
+		"PUT /_api/user/reporter/database/Reports":    okReply(`{}`),
+		"GET /_db/Reports/_api/database/current":      okReply(`{"result":{"name":"Reports"}}`),
+	}
+	tenantRoutes(routes, "acme", false)
+	tenantRoutes(routes, "globex", false)
+	ts, changes := recordingArango(t, routes)
+	defer ts.Close()
+
+	c := Config{Endpoints: []string{ts.URL}, Databases: []string{"acme", "globex"}, Concurrency: 2}
+	err := perform(context.Background(), c, migrationsOf(t,
+		"type: user\naction: create\nname: reporter\ngrants:\n  - database: Reports\n    grant: ro\n",
+		"type: aql\nname: backfill\nquery: RETURN 1\n",
+	))
+	assert.NoError(t, err)
+	var users, histories int
+	for _, change := range *changes {
+		if strings.HasPrefix(change, "POST /_api/user ") {
+			users++
+		}
+		if strings.HasPrefix(change, "POST /_db/_system/_api/document/arangomigo") {
+			histories++
+		}
+	}
+	assert.Equal(t, 1, users, "The user is shared by the tenants, so it's created once")
+	assert.Equal(t, 1, histories)
+}
+
+func TestCheckTenantMigrations(t *testing.T) {
+	c := Config{Databases: []string{"acme", "globex"}}
+	assert.EqualError(
+		t,
+		checkTenants(c, migrationsOf(t, "type: database\naction: create\nname: acme\n")),
+		"1_step.migration creates database 'acme', but the tenant databases have to exist already. "+
+			"Create them before migrating, or leave out the database creation",
+	)
+	assert.EqualError(
+		t,
+		checkTenants(c, migrationsOf(t, "type: user\naction: modify\nname: reporter\ngrants:\n  - grant: ro\n")),
+		"1_step.migration changes user 'reporter' access without naming the database. Users are shared by the "+
+			"tenant databases, so each grant and revoke needs a database",
+	)
+	assert.NoError(t, checkTenants(Config{Db: "acme"}, migrationsOf(t, "type: database\naction: create\nname: acme\n")))
+}
+
+func TestCheckTenants(t *testing.T) {
+	assert.NoError(t, checkTenants(Config{Databases: []string{"a"}}, nil))
+	assert.NoError(t, checkTenants(Config{Db: "a"}, nil))
+	assert.EqualError(
+		t,
+		checkTenants(Config{Databases: []string{"a"}, DatabasePattern: "a.*"}, nil),
+		"Please specify only one of databases, database_pattern or database_query in the config",
+	)
+	assert.EqualError(
+		t,
+		checkTenants(Config{Db: "a", DatabaseQuery: "RETURN 'b'"}, nil),
+		"Please specify either db or the tenant databases in the config, not both",
+	)
+	assert.Contains(t, checkTenants(Config{DatabasePattern: "tenant_("}, nil).Error(), "The database_pattern 'tenant_(' isn't a valid regular expression")
+}
+
+func TestSummarizeWithoutFailures(t *testing.T) {
+	assert.NoError(t, summarize([]tenantResult{{name: "acme", ran: 2}, {name: "globex"}}))
+}
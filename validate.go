@@ -0,0 +1,114 @@
+package arangomigo
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/pkg/errors"
+)
+
+// Validator is implemented by migrations that can check their own settings
+// before anything is sent to the server.
+type Validator interface {
+	Validate() error
+}
+
+// collectionUser is implemented by migrations that need existing collections.
+type collectionUser interface {
+	usesCollections() []string
+}
+
+// validate checks every migration's settings up front, so a mistake in the
+// last file doesn't leave the database half migrated. Files that already ran
+// only get a warning, since checks added since can't stop them now.
+func validate(pms []PairedMigrations, ran map[string]bool) error {
+	names := collectionNames{}
+	for _, pm := range pms {
+		err := validateOne(pm.change, names)
+		names.track(pm.change)
+		if !e(err) {
+			continue
+		}
+		if ran[pm.change.FileName()] {
+			log.Printf("Warning: %s already ran, but wouldn't pass now: %v\n", pm.change.FileName(), err)
+			continue
+		}
+		return errors.Wrapf(err, "Invalid migration %s", pm.change.FileName())
+	}
+	return nil
+}
+
+func validateOne(m Migration, names collectionNames) error {
+	if v, ok := m.(Validator); ok {
+		if err := v.Validate(); e(err) {
+			return err
+		}
+	}
+	return names.check(m)
+}
+
+// collectionNames follows collections as the migrations rename and delete
+// them, explaining why a name is no longer there.
+type collectionNames map[collectionKey]string
+
+// collectionKey is a collection's name within the database it's in. An empty
+// database is the one in the config.
+type collectionKey struct {
+	database, name string
+}
+
+func keyOf(m Migration, name string) collectionKey {
+	key := collectionKey{name: name}
+	if t, ok := m.(targeted); ok {
+		key.database = t.TargetDatabase()
+	}
+	return key
+}
+
+func (names collectionNames) check(m Migration) error {
+	u, ok := m.(collectionUser)
+	if !ok {
+		return nil
+	}
+	for _, name := range u.usesCollections() {
+		if gone, ok := names[keyOf(m, name)]; ok {
+			return errors.Errorf("Collection '%s' was %s", name, gone)
+		}
+	}
+	return nil
+}
+
+func (names collectionNames) track(m Migration) {
+	cl, ok := m.(*Collection)
+	if !ok {
+		return
+	}
+	switch cl.Action {
+	case CREATE:
+		delete(names, keyOf(m, cl.Name))
+	case DELETE:
+		names[keyOf(m, cl.Name)] = fmt.Sprintf("deleted by %s", cl.FileName())
+	case RENAME:
+		names[keyOf(m, cl.Name)] = fmt.Sprintf("renamed to '%s' by %s", cl.NewName, cl.FileName())
+		delete(names, keyOf(m, cl.NewName))
+	}
+}
+
+func (cl *Collection) usesCollections() []string {
+	if cl.Action == CREATE {
+		return nil
+	}
+	return []string{cl.Name}
+}
+
+func (i *FullTextIndex) usesCollections() []string    { return []string{i.Collection} }
+func (i *GeoIndex) usesCollections() []string         { return []string{i.Collection} }
+func (i *HashIndex) usesCollections() []string        { return []string{i.Collection} }
+func (i *PersistentIndex) usesCollections() []string  { return []string{i.Collection} }
+func (i *TTLIndex) usesCollections() []string         { return []string{i.Collection} }
+func (i *SkiplistIndex) usesCollections() []string    { return []string{i.Collection} }
+func (i *InvertedIndex) usesCollections() []string    { return []string{i.Collection} }
+func (i *MDIIndex) usesCollections() []string         { return []string{i.Collection} }
+func (i *MDIPrefixedIndex) usesCollections() []string { return []string{i.Collection} }
+func (i *ZKDIndex) usesCollections() []string         { return []string{i.Collection} }
+func (i *VectorIndex) usesCollections() []string      { return []string{i.Collection} }
@@ -0,0 +1,87 @@
+package arangomigo
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Pairs the migrations up in order, naming them like migration files.
+func migrationsOf(t *testing.T, contents ...string) []PairedMigrations {
+	var pms []PairedMigrations
+	for i, c := range contents {
+		m := parseMigration(t, c)
+		m.SetFileName(string(rune('1'+i)) + "_step.migration")
+		pms = append(pms, PairedMigrations{change: m})
+	}
+	return pms
+}
+
+func TestValidateFollowsRenames(t *testing.T) {
+	err := validate(migrationsOf(t,
+		"type: collection\naction: rename\nname: recipes\nnewName: dishes\n",
+		"type: persistentindex\naction: create\nname: byName\ncollection: dishes\nfields: [name]\n",
+		"type: collection\naction: modify\nname: recipes\nwaitforsync: true\n",
+	), nil)
+	assert.EqualError(t, err, "Invalid migration 3_step.migration: Collection 'recipes' was renamed to 'dishes' by 1_step.migration")
+}
+
+func TestValidateFollowsDeletes(t *testing.T) {
+	err := validate(migrationsOf(t,
+		"type: collection\naction: delete\nname: scratch\n",
+		"type: collection\naction: truncate\nname: scratch\n",
+	), nil)
+	assert.EqualError(t, err, "Invalid migration 2_step.migration: Collection 'scratch' was deleted by 1_step.migration")
+
+	err = validate(migrationsOf(t,
+		"type: collection\naction: delete\nname: scratch\n",
+		"type: collection\naction: create\nname: scratch\n",
+		"type: collection\naction: truncate\nname: scratch\n",
+	), nil)
+	assert.NoError(t, err, "Recreating the collection brings the name back")
+}
+
+func TestValidateRename(t *testing.T) {
+	invalid := map[string]string{
+		"action: rename\nname: c":               "Collection 'c' needs a newName to rename to",
+		"action: rename\nname: c\nnewName: c":   "Collection 'c' already has the newName 'c'",
+		"action: truncate\nname: c\nnewName: d": "Collection 'c' only takes a newName when the action is rename",
+	}
+	for options, msg := range invalid {
+		m := parseMigration(t, "type: collection\n"+options+"\n")
+		assert.EqualError(t, m.(Validator).Validate(), msg)
+	}
+}
+
+func TestValidateKeepsDatabasesApart(t *testing.T) {
+	err := validate(migrationsOf(t,
+		"type: collection\naction: delete\nname: scratch\ndatabase: Reports\n",
+		"type: collection\naction: truncate\nname: scratch\n",
+	), nil)
+	assert.NoError(t, err, "Deleting scratch in Reports leaves the config's scratch alone")
+
+	err = validate(migrationsOf(t, "type: database\naction: modify\nname: Shop\ndatabase: Reports\ndisallowed: [intern]\n"), nil)
+	assert.EqualError(t, err, "Invalid migration 1_step.migration: Database 'Shop' can't set database, the name is the database it works on")
+}
+
+func TestValidateOnlyWarnsForApplied(t *testing.T) {
+	pms := migrationsOf(t,
+		"type: collection\naction: create\nname: orders\n",
+		"type: collection\naction: modify\nname: orders\nnumberofshards: 3\n",
+	)
+	assert.EqualError(
+		t,
+		validate(pms, nil),
+		"Invalid migration 2_step.migration: Collection 'orders' can't change numberofshards after it's created",
+	)
+	assert.NoError(t, validate(pms, map[string]bool{"1_step.migration": true, "2_step.migration": true}))
+}
+
+func TestValidateFollowsDeletesForPrefixedMDI(t *testing.T) {
+	err := validate(migrationsOf(t,
+		"type: collection\naction: delete\nname: events\n",
+		"type: mdiprefixedindex\naction: create\nname: byWindow\ncollection: events\n"+
+			"fields: [from, to]\nprefixFields: [kind]\n",
+	), nil)
+	assert.EqualError(t, err, "Invalid migration 2_step.migration: Collection 'events' was deleted by 1_step.migration")
+}
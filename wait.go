@@ -0,0 +1,240 @@
+package arangomigo
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/url"
+	"path"
+	"sort"
+	"time"
+
+	"github.com/arangodb/go-driver"
+	"github.com/pkg/errors"
+)
+
+const (
+	defaultWaitInterval      = 2 * time.Second
+	defaultIndexWaitInterval = 10 * time.Second
+)
+
+// waitForArango polls until the server is available and, in a cluster, every
+// DB server reports good health. Coordinators answer long before the DB
+// servers can take shards, so creating collections too early fails.
+func waitForArango(ctx context.Context, c Config, cl driver.Client) error {
+	if c.WaitFor <= 0 {
+		return nil
+	}
+	interval := c.WaitInterval
+	if interval <= 0 {
+		interval = defaultWaitInterval
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, c.WaitFor)
+	defer cancel()
+	for {
+		why := notReady(ctx, cl)
+		if why == "" {
+			log.Println("Arango is ready")
+			return nil
+		}
+		log.Printf("Waiting for Arango: %s\n", why)
+
+		select {
+		case <-ctx.Done():
+			return errors.Errorf("Arango wasn't ready after %s: %s", c.WaitFor, why)
+		case <-time.After(interval):
+		}
+	}
+}
+
+// notReady explains why the server can't take migrations yet. Returns an
+// empty string when it can.
+func notReady(ctx context.Context, cl driver.Client) string {
+	if err := available(ctx, cl.Connection()); e(err) {
+		return fmt.Sprintf("server isn't available (%s)", err)
+	}
+
+	role, err := cl.ServerRole(ctx)
+	if e(err) {
+		return fmt.Sprintf("couldn't read the server role (%s)", err)
+	}
+	if role != driver.ServerRoleCoordinator {
+		return ""
+	}
+
+	cluster, err := cl.Cluster(ctx)
+	if e(err) {
+		return fmt.Sprintf("couldn't reach the cluster (%s)", err)
+	}
+	health, err := cluster.Health(ctx)
+	if e(err) {
+		return fmt.Sprintf("couldn't read the cluster health (%s)", err)
+	}
+
+	dbServers := 0
+	var unhealthy []string
+	for id, h := range health.Health {
+		if h.Role != driver.ServerRoleDBServer {
+			continue
+		}
+		dbServers++
+		if h.Status != driver.ServerStatusGood {
+			unhealthy = append(unhealthy, fmt.Sprintf("%s is %s", serverName(id, h), h.Status))
+		}
+	}
+	if dbServers == 0 {
+		return "the cluster has no DB servers yet"
+	}
+	if len(unhealthy) > 0 {
+		sort.Strings(unhealthy)
+		return fmt.Sprintf("DB servers aren't healthy: %v", unhealthy)
+	}
+	return ""
+}
+
+// serverName prefers the short name Arango gives a server, such as DBServer0001.
+func serverName(id driver.ServerID, h driver.ServerHealth) string {
+	if h.ShortName != "" {
+		return h.ShortName
+	}
+	return string(id)
+}
+
+// available asks the server whether it accepts requests. It answers 503
+// while starting up, shutting down or as a passive follower.
+func available(ctx context.Context, conn driver.Connection) error {
+	req, err := conn.NewRequest("GET", "_admin/server/availability")
+	if e(err) {
+		return err
+	}
+	resp, err := conn.Do(ctx, req)
+	if e(err) {
+		return err
+	}
+	return resp.CheckStatus(200)
+}
+
+// indexWait carries the connection, and the config's index_wait, to the
+// index migrations, which only get a context and the database.
+type indexWait struct {
+	conn     driver.Connection
+	timeout  time.Duration
+	interval time.Duration
+}
+
+type indexWaitKey struct{}
+
+func withIndexWait(ctx context.Context, c Config, conn driver.Connection) context.Context {
+	interval := c.IndexWaitInterval
+	if interval <= 0 {
+		interval = defaultIndexWaitInterval
+	}
+	return context.WithValue(ctx, indexWaitKey{}, indexWait{conn: conn, timeout: c.IndexWait, interval: interval})
+}
+
+// awaitIndex polls until a new index has finished building, when index_wait
+// is set. Indexes built in the background, especially on a cluster, are still
+// filling after Arango answers, and queries in the next migration would only
+// see part of the data.
+func awaitIndex(ctx context.Context, cl driver.Collection, name string) error {
+	w, ok := ctx.Value(indexWaitKey{}).(indexWait)
+	if !ok || w.timeout <= 0 {
+		return nil
+	}
+	return w.poll(ctx, cl, name)
+}
+
+// awaitReplacement polls until the new version of an index modify builds has
+// finished, whether or not index_wait is set, so the old one isn't dropped
+// while queries still need it. index_wait still bounds the wait.
+func awaitReplacement(ctx context.Context, cl driver.Collection, name string) error {
+	w, ok := ctx.Value(indexWaitKey{}).(indexWait)
+	if !ok {
+		log.Printf("Can't check that index '%s' on collection %s has finished building\n", name, cl.Name())
+		return nil
+	}
+	return w.poll(ctx, cl, name)
+}
+
+func (w indexWait) poll(ctx context.Context, cl driver.Collection, name string) error {
+	if w.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, w.timeout)
+		defer cancel()
+	}
+	started := time.Now()
+	var progress float64
+	timedOut := func() error {
+		waited := w.timeout
+		if waited <= 0 {
+			waited = time.Since(started).Round(time.Second)
+		}
+		return errors.Errorf(
+			"Index '%s' on collection %s was still building after %s, %.1f%% done",
+			name, cl.Name(), waited, progress,
+		)
+	}
+	for polls := 0; ; polls++ {
+		building, done, err := indexProgress(ctx, w.conn, cl.Database().Name(), cl.Name(), name)
+		if ctx.Err() != nil {
+			return timedOut()
+		} else if e(err) {
+			return errors.Wrapf(err, "Couldn't read the progress of index '%s' on collection %s", name, cl.Name())
+		}
+		if !building {
+			if polls > 0 {
+				log.Printf(
+					"Index '%s' on collection %s finished building after %s\n",
+					name, cl.Name(), time.Since(started).Round(time.Second),
+				)
+			}
+			return nil
+		}
+		progress = done
+		log.Printf(
+			"Index '%s' on collection %s is still building, %.1f%% done after %s\n",
+			name, cl.Name(), progress, time.Since(started).Round(time.Second),
+		)
+
+		select {
+		case <-ctx.Done():
+			return timedOut()
+		case <-time.After(w.interval):
+		}
+	}
+}
+
+// indexProgress finds whether the index is still building and how far along
+// it is. Indexes being built are only listed with withHidden.
+func indexProgress(ctx context.Context, conn driver.Connection, db, collection, name string) (bool, float64, error) {
+	req, err := conn.NewRequest("GET", path.Join("_db", url.PathEscape(db), "_api/index"))
+	if e(err) {
+		return false, 0, err
+	}
+	req.SetQuery("collection", collection)
+	req.SetQuery("withHidden", "true")
+	resp, err := conn.Do(ctx, req)
+	if e(err) {
+		return false, 0, err
+	}
+	if err := resp.CheckStatus(200); e(err) {
+		return false, 0, err
+	}
+	data := struct {
+		Indexes []struct {
+			Name       string  `json:"name"`
+			IsBuilding bool    `json:"isBuilding"`
+			Progress   float64 `json:"progress"`
+		} `json:"indexes"`
+	}{}
+	if err := resp.ParseBody("", &data); e(err) {
+		return false, 0, err
+	}
+	for _, i := range data.Indexes {
+		if i.Name == name {
+			return i.IsBuilding, i.Progress, nil
+		}
+	}
+	return false, 0, nil
+}
@@ -0,0 +1,147 @@
+package arangomigo
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const healthyCluster = `{"ClusterId":"c1","Health":{
+	"CRDN-1":{"Role":"Coordinator","Status":"GOOD","ShortName":"Coordinator0001"},
+	"PRMR-1":{"Role":"DBServer","Status":"GOOD","ShortName":"DBServer0001"},
+	"PRMR-2":{"Role":"DBServer","Status":"GOOD","ShortName":"DBServer0002"}}}`
+
+const recoveringCluster = `{"ClusterId":"c1","Health":{
+	"CRDN-1":{"Role":"Coordinator","Status":"GOOD","ShortName":"Coordinator0001"},
+	"PRMR-1":{"Role":"DBServer","Status":"GOOD","ShortName":"DBServer0001"},
+	"PRMR-2":{"Role":"DBServer","Status":"BAD","ShortName":"DBServer0002"}}}`
+
+// A coordinator whose DB servers become healthy after the given number of
+// health checks.
+func startingCluster(healthyAfter int32) (*httptest.Server, *int32) {
+	checks := new(int32)
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/_admin/server/availability":
+			w.Write([]byte(`{"mode":"default","readOnly":false}`))
+		case "/_admin/server/role":
+			w.Write([]byte(`{"role":"COORDINATOR","mode":"default"}`))
+		case "/_admin/cluster/health":
+			if atomic.AddInt32(checks, 1) > healthyAfter {
+				w.Write([]byte(healthyCluster))
+			} else {
+				w.Write([]byte(recoveringCluster))
+			}
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	})), checks
+}
+
+func TestWaitForHealthyCluster(t *testing.T) {
+	ts, checks := startingCluster(2)
+	defer ts.Close()
+
+	c := Config{Endpoints: []string{ts.URL}, WaitFor: time.Second, WaitInterval: time.Millisecond}
+	cl, err := client(c)
+	assert.NoError(t, err)
+
+	err = waitForArango(context.Background(), c, cl)
+	assert.NoError(t, err)
+	assert.Equal(t, int32(3), atomic.LoadInt32(checks), "Should stop polling once healthy")
+}
+
+func TestWaitForTimesOut(t *testing.T) {
+	ts, _ := startingCluster(1 << 30)
+	defer ts.Close()
+
+	c := Config{Endpoints: []string{ts.URL}, WaitFor: 50 * time.Millisecond, WaitInterval: 10 * time.Millisecond}
+	cl, err := client(c)
+	assert.NoError(t, err)
+
+	err = waitForArango(context.Background(), c, cl)
+	assert.Contains(t, err.Error(), "Arango wasn't ready after 50ms")
+}
+
+func TestWaitForSingleServer(t *testing.T) {
+	ts := fakeArango(t, map[string]reply{
+		"GET /_admin/server/availability": okReply(`{"mode":"default"}`),
+		"GET /_admin/server/role":         okReply(`{"role":"SINGLE","mode":"default"}`),
+	})
+	defer ts.Close()
+
+	c := Config{Endpoints: []string{ts.URL}, WaitFor: time.Second}
+	cl, err := client(c)
+	assert.NoError(t, err)
+	assert.NoError(t, waitForArango(context.Background(), c, cl), "A single server has no cluster health to wait on")
+}
+
+// A collection whose index finishes building after the given number of
+// progress checks.
+func buildingIndex(builtAfter int32) (*httptest.Server, *int32) {
+	checks := new(int32)
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/_db/Shop/_api/database/current":
+			w.Write([]byte(`{"result":{"name":"Shop"}}`))
+		case "/_db/Shop/_api/collection/products":
+			w.Write([]byte(`{"name":"products"}`))
+		case "/_db/Shop/_api/index":
+			if r.URL.Query().Get("collection") != "products" || r.URL.Query().Get("withHidden") != "true" {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			if n := atomic.AddInt32(checks, 1); n > builtAfter {
+				w.Write([]byte(`{"indexes":[{"name":"primary"},{"name":"bySku"}]}`))
+			} else {
+				w.Write([]byte(fmt.Sprintf(`{"indexes":[{"name":"primary"},{"name":"bySku","isBuilding":true,"progress":%d}]}`, n*30)))
+			}
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	})), checks
+}
+
+func TestAwaitIndex(t *testing.T) {
+	ts, checks := buildingIndex(2)
+	defer ts.Close()
+	c := Config{Endpoints: []string{ts.URL}, IndexWait: time.Second, IndexWaitInterval: time.Millisecond}
+	cl, err := client(c)
+	assert.NoError(t, err)
+	db, err := cl.Database(context.Background(), "Shop")
+	assert.NoError(t, err)
+	col, err := db.Collection(context.Background(), "products")
+	assert.NoError(t, err)
+
+	// Without index_wait nothing is polled.
+	assert.NoError(t, awaitIndex(context.Background(), col, "bySku"))
+	assert.Equal(t, int32(0), atomic.LoadInt32(checks))
+
+	ctx := withIndexWait(context.Background(), c, cl.Connection())
+	assert.NoError(t, awaitIndex(ctx, col, "bySku"))
+	assert.Equal(t, int32(3), atomic.LoadInt32(checks), "Should stop polling once built")
+}
+
+func TestAwaitIndexTimesOut(t *testing.T) {
+	ts, _ := buildingIndex(1000)
+	defer ts.Close()
+	c := Config{Endpoints: []string{ts.URL}, IndexWait: 50 * time.Millisecond, IndexWaitInterval: 20 * time.Millisecond}
+	cl, err := client(c)
+	assert.NoError(t, err)
+	db, err := cl.Database(context.Background(), "Shop")
+	assert.NoError(t, err)
+	col, err := db.Collection(context.Background(), "products")
+	assert.NoError(t, err)
+
+	err = awaitIndex(withIndexWait(context.Background(), c, cl.Connection()), col, "bySku")
+	assert.Error(t, err)
+	assert.Regexp(t, `^Index 'bySku' on collection products was still building after 50ms, \d+\.0% done$`, err.Error())
+}